@@ -0,0 +1,143 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	// cgroupUnified is hierarchy ID of cgroup v2 unified hierarchy in /proc/<pid>/cgroup
+	cgroupUnified = "0"
+	// cgroupSystemd is name of cgroup v1 hierarchy maintained by systemd
+	cgroupSystemd = "name=systemd"
+	// Suffixes of cgroups of systemd units which processes belong to
+	unitService = ".service"
+	unitScope   = ".scope"
+
+	// cgroupRoot is name of the root cgroup, it follows name of the root slice of systemd (-.slice)
+	cgroupRoot = "-"
+
+	// kubepods is name of the cgroup of Kubernetes pods (or prefix of its slice with systemd cgroup driver)
+	kubepods = "kubepods"
+)
+
+var (
+	// containerCgroup matches cgroups of containers and captures the container ID, e.g. /docker/<id> (cgroupfs driver),
+	// docker-<id>.scope (systemd driver), cri-containerd-<id>.scope or crio-<id>.scope
+	containerCgroup = regexp.MustCompile(`^(?:(?:docker|cri-containerd|crio|libpod)-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+	// podCgroup matches cgroups of Kubernetes pods and captures the pod UID, e.g. pod<uid> (cgroupfs driver)
+	// or kubepods-burstable-pod<uid>.slice where dashes of the UID are replaced by underscores (systemd driver)
+	podCgroup = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})(?:\.slice)?$`)
+)
+
+// readCgroup returns path of cgroup of the process from cgroup file specified by fileName
+func readCgroup(fileName string) (string, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return parseCgroup(string(content)), nil
+}
+
+// parseCgroup returns path of cgroup from content of /proc/<pid>/cgroup, path in cgroup v2 unified hierarchy
+// is preferred, on hosts with cgroup v1 only path in hierarchy of systemd or the first listed hierarchy is used
+func parseCgroup(content string) string {
+	paths := map[string]string{}
+	first := ""
+	for _, line := range strings.Split(content, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path, controller list is empty for cgroup v2
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 3 || fields[2] == "" {
+			continue
+		}
+		key := fields[1]
+		if fields[0] == cgroupUnified && key == "" {
+			key = cgroupUnified
+		}
+		paths[key] = fields[2]
+		if first == "" {
+			first = fields[2]
+		}
+	}
+	if path, ok := paths[cgroupUnified]; ok {
+		return path
+	}
+	if path, ok := paths[cgroupSystemd]; ok {
+		return path
+	}
+	return first
+}
+
+// cgroupName returns path of cgroup relative to the root cgroup truncated to given depth,
+// with separators replaced to fit in a single namespace element,
+// e.g. system.slice.nginx.service for /system.slice/nginx.service/worker and depth 2;
+// path is not truncated when depth is 0
+func cgroupName(path string, depth int) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return cgroupRoot
+	}
+	if elements := strings.Split(path, "/"); depth > 0 && len(elements) > depth {
+		path = strings.Join(elements[:depth], "/")
+	}
+	return removeUnwantedChars(path)
+}
+
+// cgroupUnit returns name of systemd unit (service or scope) owning cgroup path, e.g. nginx.service
+// for /system.slice/nginx.service/worker; units of user managers are nested in unit of the manager
+// (e.g. user@1000.service), the outermost unit is returned as systemd does for the system manager;
+// empty string is returned for cgroups outside of units (e.g. the root cgroup of kernel threads)
+func cgroupUnit(path string) string {
+	for _, element := range strings.Split(path, "/") {
+		if strings.HasSuffix(element, unitService) || strings.HasSuffix(element, unitScope) {
+			return element
+		}
+	}
+	return ""
+}
+
+// cgroupContainer returns ID of the container owning cgroup path, the innermost container is returned
+// for nested containers; empty string is returned for cgroups outside of containers
+func cgroupContainer(path string) string {
+	elements := strings.Split(path, "/")
+	for i := len(elements) - 1; i >= 0; i-- {
+		if match := containerCgroup.FindStringSubmatch(elements[i]); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// cgroupPod returns UID of Kubernetes pod owning cgroup path, empty string is returned for cgroups outside of pods
+func cgroupPod(path string) string {
+	if !strings.Contains(path, kubepods) {
+		return ""
+	}
+	for _, element := range strings.Split(path, "/") {
+		if match := podCgroup.FindStringSubmatch(element); match != nil {
+			return strings.Replace(match[1], "_", "-", -1)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseCgroup(t *testing.T) {
+
+	Convey("when host uses cgroup v2 unified hierarchy", t, func() {
+		So(parseCgroup("0::/system.slice/nginx.service\n"), ShouldEqual, "/system.slice/nginx.service")
+	})
+
+	Convey("when host uses cgroup v1 hierarchies", t, func() {
+		content := "12:cpu,cpuacct:/system.slice/nginx.service\n" +
+			"11:memory:/system.slice/nginx.service\n" +
+			"1:name=systemd:/system.slice/nginx.service\n"
+
+		So(parseCgroup(content), ShouldEqual, "/system.slice/nginx.service")
+
+		Convey("and systemd hierarchy is not mounted", func() {
+			So(parseCgroup("4:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n"), ShouldEqual, "/docker/abc")
+		})
+	})
+
+	Convey("when host uses hybrid hierarchies", t, func() {
+		content := "12:memory:/user.slice\n" +
+			"1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n" +
+			"0::/user.slice/user-1000.slice/session-2.scope\n"
+
+		So(parseCgroup(content), ShouldEqual, "/user.slice/user-1000.slice/session-2.scope")
+	})
+
+	Convey("when cgroup file is empty", t, func() {
+		So(parseCgroup(""), ShouldBeEmpty)
+	})
+}
+
+func TestCgroupName(t *testing.T) {
+
+	Convey("when cgroup path is deeper than depth", t, func() {
+		So(cgroupName("/system.slice/nginx.service/worker", 2), ShouldEqual, "system.slice.nginx.service")
+		So(cgroupName("/system.slice/nginx.service/worker", 1), ShouldEqual, "system.slice")
+	})
+
+	Convey("when cgroup path is not deeper than depth", t, func() {
+		So(cgroupName("/system.slice/nginx.service", 2), ShouldEqual, "system.slice.nginx.service")
+	})
+
+	Convey("when depth is not limited", t, func() {
+		So(cgroupName("/system.slice/nginx.service/worker", 0), ShouldEqual, "system.slice.nginx.service.worker")
+	})
+
+	Convey("when process is in the root cgroup", t, func() {
+		So(cgroupName("/", 2), ShouldEqual, cgroupRoot)
+	})
+}
+
+func TestCgroupUnit(t *testing.T) {
+
+	Convey("when process belongs to a service", t, func() {
+		So(cgroupUnit("/system.slice/nginx.service"), ShouldEqual, "nginx.service")
+		So(cgroupUnit("/system.slice/system-getty.slice/getty@tty1.service"), ShouldEqual, "getty@tty1.service")
+	})
+
+	Convey("when process is in a nested cgroup of a service", t, func() {
+		So(cgroupUnit("/system.slice/nginx.service/worker"), ShouldEqual, "nginx.service")
+	})
+
+	Convey("when process belongs to a scope", t, func() {
+		So(cgroupUnit("/user.slice/user-1000.slice/session-2.scope"), ShouldEqual, "session-2.scope")
+		So(cgroupUnit("/init.scope"), ShouldEqual, "init.scope")
+	})
+
+	Convey("when process belongs to a unit of user manager", t, func() {
+		So(cgroupUnit("/user.slice/user-1000.slice/user@1000.service/app.slice/pipewire.service"), ShouldEqual, "user@1000.service")
+	})
+
+	Convey("when process does not belong to any unit", t, func() {
+		So(cgroupUnit("/"), ShouldBeEmpty)
+		So(cgroupUnit("/user.slice"), ShouldBeEmpty)
+		So(cgroupUnit(""), ShouldBeEmpty)
+	})
+}
+
+func TestCgroupContainer(t *testing.T) {
+
+	containerID := "3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+	Convey("when process runs in docker container", t, func() {
+		// cgroupfs and systemd cgroup drivers
+		So(cgroupContainer("/docker/"+containerID), ShouldEqual, containerID)
+		So(cgroupContainer("/system.slice/docker-"+containerID+".scope"), ShouldEqual, containerID)
+	})
+
+	Convey("when process runs in container of Kubernetes pod", t, func() {
+		So(cgroupContainer("/kubepods/burstable/pod0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0/"+containerID), ShouldEqual, containerID)
+		So(cgroupContainer("/kubepods.slice/kubepods-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/cri-containerd-"+containerID+".scope"),
+			ShouldEqual, containerID)
+		So(cgroupContainer("/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/crio-"+
+			containerID+".scope"), ShouldEqual, containerID)
+	})
+
+	Convey("when process runs in nested cgroup of container", t, func() {
+		So(cgroupContainer("/system.slice/docker-"+containerID+".scope/init.scope"), ShouldEqual, containerID)
+	})
+
+	Convey("when process does not run in a container", t, func() {
+		So(cgroupContainer("/system.slice/docker.service"), ShouldBeEmpty)
+		So(cgroupContainer("/system.slice/crio-conmon-"+containerID+".scope"), ShouldBeEmpty)
+		So(cgroupContainer("/"), ShouldBeEmpty)
+	})
+}
+
+func TestCgroupPod(t *testing.T) {
+
+	podUID := "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+
+	Convey("when process runs in Kubernetes pod", t, func() {
+		// cgroupfs and systemd cgroup drivers
+		So(cgroupPod("/kubepods/burstable/pod"+podUID+"/3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"), ShouldEqual, podUID)
+		So(cgroupPod("/kubepods/pod"+podUID+"/3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"), ShouldEqual, podUID)
+		So(cgroupPod("/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/"+
+			"cri-containerd-3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f.scope"), ShouldEqual, podUID)
+	})
+
+	Convey("when process does not run in Kubernetes pod", t, func() {
+		So(cgroupPod("/docker/3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"), ShouldBeEmpty)
+		So(cgroupPod("/kubepods.slice"), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,106 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strconv"
+	"unsafe"
+)
+
+const (
+	// auxvPath is auxiliary vector of the plugin process, it holds clock tick rate of the kernel
+	auxvPath = "/proc/self/auxv"
+	// atClkTck is auxiliary vector entry type of the clock tick rate (AT_CLKTCK)
+	atClkTck = 17
+	// defaultClockTicks is USER_HZ used when the rate cannot be read from auxiliary vector
+	defaultClockTicks = 100
+	// maxClockTicks is upper bound of plausible clock tick rate, greater values are decoded wrongly
+	maxClockTicks = 10000
+)
+
+// setCPUSeconds sets CPU times converted from jiffies to seconds
+func setCPUSeconds(procMetrics map[string]interface{}, clockTicks float64) {
+	if utime, ok := procMetrics["ps_cputime_user"].(uint64); ok {
+		procMetrics["ps_cputime_user_seconds"] = float64(utime) / clockTicks
+	}
+	if stime, ok := procMetrics["ps_cputime_system"].(uint64); ok {
+		procMetrics["ps_cputime_system_seconds"] = float64(stime) / clockTicks
+	}
+}
+
+// setCPUPercent sets CPU utilization metrics calculated from per-second rates of CPU times in jiffies;
+// with cpuCount greater than 1 utilization is normalized to all CPUs of the host
+func setCPUPercent(procMetrics map[string]interface{}, rates map[string]float64, clockTicks float64, cpuCount int) {
+	user, okUser := rates["ps_cputime_user"]
+	system, okSystem := rates["ps_cputime_system"]
+	if !okUser || !okSystem {
+		return
+	}
+	if cpuCount < 1 {
+		cpuCount = 1
+	}
+	scale := 100 / clockTicks / float64(cpuCount)
+
+	procMetrics["ps_cpu_percent_user"] = user * scale
+	procMetrics["ps_cpu_percent_system"] = system * scale
+	procMetrics["ps_cpu_percent_total"] = (user + system) * scale
+}
+
+// getClockTicks returns number of clock ticks per second (USER_HZ) used by the kernel in procfs
+func getClockTicks() uint64 {
+	auxv, err := ioutil.ReadFile(auxvPath)
+	if err != nil {
+		return defaultClockTicks
+	}
+	return parseClockTicks(auxv, nativeByteOrder(), strconv.IntSize/8)
+}
+
+// parseClockTicks returns clock tick rate from auxiliary vector, which is a list of (type, value) pairs
+// of native words of given size and byte order; default rate is returned when it is missing or implausible
+func parseClockTicks(auxv []byte, order binary.ByteOrder, wordSize int) uint64 {
+	for i := 0; i+2*wordSize <= len(auxv); i += 2 * wordSize {
+		var typ, val uint64
+		if wordSize == 8 {
+			typ = order.Uint64(auxv[i:])
+			val = order.Uint64(auxv[i+wordSize:])
+		} else {
+			typ = uint64(order.Uint32(auxv[i:]))
+			val = uint64(order.Uint32(auxv[i+wordSize:]))
+		}
+		if typ == atClkTck {
+			if val > 0 && val <= maxClockTicks {
+				return val
+			}
+			break
+		}
+	}
+	return defaultClockTicks
+}
+
+// nativeByteOrder returns byte order of the host, the auxiliary vector is written in it
+func nativeByteOrder() binary.ByteOrder {
+	probe := uint16(1)
+	if *(*byte)(unsafe.Pointer(&probe)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
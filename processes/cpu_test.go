@@ -0,0 +1,116 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetCPUSeconds(t *testing.T) {
+
+	Convey("when CPU times in jiffies are available", t, func() {
+		procMetrics := map[string]interface{}{"ps_cputime_user": uint64(250), "ps_cputime_system": uint64(50)}
+		setCPUSeconds(procMetrics, 100)
+
+		So(procMetrics["ps_cputime_user_seconds"], ShouldEqual, 2.5)
+		So(procMetrics["ps_cputime_system_seconds"], ShouldEqual, 0.5)
+		// CPU times in jiffies are kept for backward compatibility
+		So(procMetrics["ps_cputime_user"], ShouldEqual, 250)
+		So(procMetrics["ps_cputime_system"], ShouldEqual, 50)
+	})
+
+	Convey("when clock ticks rate is different", t, func() {
+		procMetrics := map[string]interface{}{"ps_cputime_user": uint64(250), "ps_cputime_system": uint64(50)}
+		setCPUSeconds(procMetrics, 250)
+
+		So(procMetrics["ps_cputime_user_seconds"], ShouldEqual, 1)
+		So(procMetrics["ps_cputime_system_seconds"], ShouldEqual, 0.2)
+	})
+}
+
+func TestSetCPUPercent(t *testing.T) {
+
+	Convey("when rates of CPU times are available", t, func() {
+		rates := map[string]float64{"ps_cputime_user": 50, "ps_cputime_system": 25}
+
+		Convey("utilization is relative to a single CPU", func() {
+			procMetrics := map[string]interface{}{}
+			setCPUPercent(procMetrics, rates, 100, 1)
+
+			So(procMetrics["ps_cpu_percent_user"], ShouldEqual, 50)
+			So(procMetrics["ps_cpu_percent_system"], ShouldEqual, 25)
+			So(procMetrics["ps_cpu_percent_total"], ShouldEqual, 75)
+		})
+
+		Convey("utilization is normalized to number of CPUs", func() {
+			procMetrics := map[string]interface{}{}
+			setCPUPercent(procMetrics, rates, 100, 4)
+
+			So(procMetrics["ps_cpu_percent_user"], ShouldEqual, 12.5)
+			So(procMetrics["ps_cpu_percent_system"], ShouldEqual, 6.25)
+			So(procMetrics["ps_cpu_percent_total"], ShouldEqual, 18.75)
+		})
+	})
+
+	Convey("when rates of CPU times are not available", t, func() {
+		procMetrics := map[string]interface{}{}
+		setCPUPercent(procMetrics, nil, 100, 1)
+
+		So(procMetrics, ShouldBeEmpty)
+	})
+
+	Convey("clock ticks rate is available", t, func() {
+		So(getClockTicks(), ShouldBeGreaterThan, 0)
+	})
+
+	// auxiliary vector with AT_PAGESZ (6) and AT_CLKTCK (17) entries followed by AT_NULL
+	auxv64 := func(order binary.ByteOrder, clockTicks uint64) []byte {
+		auxv := make([]byte, 48)
+		order.PutUint64(auxv[0:], 6)
+		order.PutUint64(auxv[8:], 4096)
+		order.PutUint64(auxv[16:], atClkTck)
+		order.PutUint64(auxv[24:], clockTicks)
+		return auxv
+	}
+
+	Convey("when auxiliary vector is in byte order of the host", t, func() {
+		So(parseClockTicks(auxv64(binary.LittleEndian, 250), binary.LittleEndian, 8), ShouldEqual, 250)
+		So(parseClockTicks(auxv64(binary.BigEndian, 250), binary.BigEndian, 8), ShouldEqual, 250)
+
+		auxv32 := make([]byte, 16)
+		binary.BigEndian.PutUint32(auxv32[0:], atClkTck)
+		binary.BigEndian.PutUint32(auxv32[4:], 100)
+		So(parseClockTicks(auxv32, binary.BigEndian, 4), ShouldEqual, 100)
+	})
+
+	Convey("when clock ticks rate decoded from auxiliary vector is implausible", t, func() {
+		So(parseClockTicks(auxv64(binary.BigEndian, 250), binary.LittleEndian, 8), ShouldEqual, defaultClockTicks)
+		So(parseClockTicks(auxv64(binary.LittleEndian, 0), binary.LittleEndian, 8), ShouldEqual, defaultClockTicks)
+	})
+
+	Convey("when auxiliary vector has no clock ticks rate", t, func() {
+		So(parseClockTicks(nil, nativeByteOrder(), 8), ShouldEqual, defaultClockTicks)
+	})
+}
@@ -0,0 +1,147 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// pfKthread is flag of kernel threads in /proc/<pid>/stat (PF_KTHREAD)
+	pfKthread = 0x00200000
+
+	// listSep separates values of list config options, e.g. include_names
+	listSep = ","
+)
+
+// procFilter selects processes reported by process metrics,
+// excluded processes are counted only in process states
+type procFilter struct {
+	// includeNames are shell patterns of names of included processes, all are included when empty
+	includeNames []string
+	// excludeNames are shell patterns of names of excluded processes
+	excludeNames []string
+	// includeCmdline matches command line of included processes, all are included when nil
+	includeCmdline *regexp.Regexp
+	// excludeUids are effective UIDs of excluded processes
+	excludeUids map[int]bool
+	// excludeKernelThreads excludes kernel threads
+	excludeKernelThreads bool
+}
+
+// newProcFilter returns filter of processes built from config options,
+// nil is returned when no process is excluded
+func newProcFilter(includeNames, excludeNames, includeCmdline string, excludeUids map[int]bool, excludeKernelThreads bool) (*procFilter, error) {
+	filter := &procFilter{
+		includeNames:         splitList(includeNames),
+		excludeNames:         splitList(excludeNames),
+		excludeUids:          excludeUids,
+		excludeKernelThreads: excludeKernelThreads,
+	}
+	for _, pattern := range append(append([]string{}, filter.includeNames...), filter.excludeNames...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %v", pattern, err)
+		}
+	}
+	if includeCmdline != "" {
+		re, err := regexp.Compile(includeCmdline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command line regular expression: %v", err)
+		}
+		filter.includeCmdline = re
+	}
+
+	if len(filter.includeNames) == 0 && len(filter.excludeNames) == 0 && filter.includeCmdline == nil &&
+		len(filter.excludeUids) == 0 && !filter.excludeKernelThreads {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// files returns files of /proc/<pid> needed by the filter, nameFiles are files needed to name processes
+func (f *procFilter) files(nameFiles ...string) []string {
+	files := []string{}
+	if f == nil {
+		return files
+	}
+	if len(f.includeNames) > 0 || len(f.excludeNames) > 0 {
+		files = append(files, nameFiles...)
+	}
+	if f.includeCmdline != nil {
+		files = append(files, procCmd)
+	}
+	if len(f.excludeUids) > 0 {
+		files = append(files, procStatus)
+	}
+	return files
+}
+
+// excludesStat tells if process is excluded based on its stat
+func (f *procFilter) excludesStat(stat ProcStat) bool {
+	if f == nil {
+		return false
+	}
+	return f.excludeKernelThreads && stat.Flags&pfKthread != 0
+}
+
+// excludes tells if process with given name is excluded based on files read before naming it
+func (f *procFilter) excludes(name string, instance Proc) bool {
+	if f == nil {
+		return false
+	}
+	if len(f.includeNames) > 0 && !matchesAny(f.includeNames, name) {
+		return true
+	}
+	if matchesAny(f.excludeNames, name) {
+		return true
+	}
+	if f.includeCmdline != nil && !f.includeCmdline.MatchString(instance.CmdLine) {
+		return true
+	}
+	// processes with unknown owner are not excluded
+	if len(instance.Uids) > uidEffective && f.excludeUids[instance.Uids[uidEffective]] {
+		return true
+	}
+	return false
+}
+
+// matchesAny tells if name matches any of shell patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList returns non-empty values of list config option
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, listSep) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,100 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProcFilter(t *testing.T) {
+
+	Convey("when no process is excluded", t, func() {
+		filter, err := newProcFilter("", " , ", "", map[int]bool{}, false)
+
+		So(err, ShouldBeNil)
+		So(filter, ShouldBeNil)
+		// nil filter includes all processes
+		So(filter.excludesStat(ProcStat{Flags: pfKthread}), ShouldBeFalse)
+		So(filter.excludes("nginx", Proc{}), ShouldBeFalse)
+		So(filter.files(procCmd), ShouldBeEmpty)
+	})
+
+	Convey("when filter options are invalid", t, func() {
+		_, err := newProcFilter("[nginx", "", "", nil, false)
+		So(err, ShouldNotBeNil)
+
+		_, err = newProcFilter("", "", "(nginx", nil, false)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("when kernel threads are excluded", t, func() {
+		filter, err := newProcFilter("", "", "", nil, true)
+
+		So(err, ShouldBeNil)
+		So(filter.excludesStat(ProcStat{Flags: 0x00208040}), ShouldBeTrue)
+		So(filter.excludesStat(ProcStat{Flags: 0x00400100}), ShouldBeFalse)
+		So(filter.files(procCmd), ShouldBeEmpty)
+	})
+
+	Convey("when processes are included by names", t, func() {
+		filter, err := newProcFilter("nginx, php-fpm*", "", "", nil, false)
+
+		So(err, ShouldBeNil)
+		So(filter.excludes("nginx", Proc{}), ShouldBeFalse)
+		So(filter.excludes("php-fpm7.4", Proc{}), ShouldBeFalse)
+		So(filter.excludes("sshd", Proc{}), ShouldBeTrue)
+		So(filter.files(procExe), ShouldResemble, []string{procExe})
+	})
+
+	Convey("when processes are excluded by names", t, func() {
+		filter, err := newProcFilter("", "kworker*,sshd", "", nil, false)
+
+		So(err, ShouldBeNil)
+		So(filter.excludes("kworker.0:1", Proc{}), ShouldBeTrue)
+		So(filter.excludes("sshd", Proc{}), ShouldBeTrue)
+		So(filter.excludes("nginx", Proc{}), ShouldBeFalse)
+	})
+
+	Convey("when processes are included by command line", t, func() {
+		filter, err := newProcFilter("", "", `--queue=\w+`, nil, false)
+
+		So(err, ShouldBeNil)
+		So(filter.includeCmdline, ShouldResemble, regexp.MustCompile(`--queue=\w+`))
+		So(filter.excludes("python", Proc{CmdLine: "python etl_worker.py --queue=orders"}), ShouldBeFalse)
+		So(filter.excludes("python", Proc{CmdLine: "python manage.py runserver"}), ShouldBeTrue)
+		So(filter.files(), ShouldResemble, []string{procCmd})
+	})
+
+	Convey("when processes are excluded by users", t, func() {
+		filter, err := newProcFilter("", "", "", map[int]bool{0: true}, false)
+
+		So(err, ShouldBeNil)
+		So(filter.excludes("sshd", Proc{Uids: []int{1000, 0, 0, 0}}), ShouldBeTrue)
+		So(filter.excludes("bash", Proc{Uids: []int{0, 1000, 1000, 1000}}), ShouldBeFalse)
+		// processes with unknown owner are not excluded
+		So(filter.excludes("bash", Proc{}), ShouldBeFalse)
+		So(filter.files(), ShouldResemble, []string{procStatus})
+	})
+}
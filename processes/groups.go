@@ -0,0 +1,183 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Categories of metrics aggregated over groups of processes
+	groupUser      = "user"
+	groupCgroup    = "cgroup"
+	groupUnit      = "unit"
+	groupContainer = "container"
+	groupAncestor  = "ancestor"
+
+	// defaultGroupBy lists groupings of processes enabled when group_by is not configured
+	defaultGroupBy = "user,cgroup,container"
+	// defaultCgroupDepth is number of levels of cgroup path processes are grouped by when cgroup_depth is not configured
+	defaultCgroupDepth = 2
+
+	// Values of user_uid config selecting UID which processes are grouped by
+	userUIDReal      = "real"
+	userUIDEffective = "effective"
+)
+
+// groupings describes categories of metrics aggregated over groups of processes sharing an attribute
+var groupings = map[string]grouping{
+	groupUser: grouping{
+		mode:        "user",
+		element:     "user_name",
+		description: "name of the user owning the processes",
+		sources:     []string{procStatus},
+	},
+	groupCgroup: grouping{
+		mode:        "cgroup",
+		element:     "cgroup_path",
+		description: "path of the cgroup of the processes",
+		sources:     []string{procCgroup},
+	},
+	groupUnit: grouping{
+		mode:        "systemd_unit",
+		element:     "unit_name",
+		description: "name of the systemd unit of the processes",
+		sources:     []string{procCgroup},
+	},
+	groupContainer: grouping{
+		mode:        "container",
+		element:     "container_id",
+		description: "identifier of the container of the processes",
+		sources:     []string{procCgroup},
+	},
+	groupAncestor: grouping{
+		mode:        "ancestor",
+		element:     "ancestor",
+		description: "name and PID of the top-level process of the subtree of the processes",
+	},
+}
+
+// grouping describes namespace of metrics aggregated over groups of processes,
+// e.g. /intel/procfs/processes/user/<user_name>/<metric>
+type grouping struct {
+	// mode is name of the grouping in group_by config
+	mode string
+	// element is name of the dynamic namespace element holding the group
+	element     string
+	description string
+	// sources are files of /proc/<pid> needed to assign processes to groups
+	sources []string
+}
+
+// groupKey returns group of the process, false is returned when the process cannot be assigned to any group
+type groupKey func(instance Proc) (string, bool)
+
+// userKey groups processes by name of the user whose UID is at uidIndex of Uid line of status,
+// UID is used as name of users missing in passwd
+func userKey(passwd map[int]string, uidIndex int) groupKey {
+	return func(instance Proc) (string, bool) {
+		if len(instance.Uids) <= uidIndex {
+			return "", false
+		}
+		uid := instance.Uids[uidIndex]
+		if name, ok := passwd[uid]; ok {
+			return name, true
+		}
+		return strconv.Itoa(uid), true
+	}
+}
+
+// cgroupKey groups processes by path of their cgroup truncated to depth, path is not truncated when depth is 0
+func cgroupKey(depth int) groupKey {
+	return func(instance Proc) (string, bool) {
+		if instance.Cgroup == "" {
+			return "", false
+		}
+		return cgroupName(instance.Cgroup, depth), true
+	}
+}
+
+// unitKey groups processes by systemd unit derived from path of their cgroup
+func unitKey() groupKey {
+	return func(instance Proc) (string, bool) {
+		unit := cgroupUnit(instance.Cgroup)
+		return unit, unit != ""
+	}
+}
+
+// containerKey groups processes by ID of the container derived from path of their cgroup
+func containerKey() groupKey {
+	return func(instance Proc) (string, bool) {
+		container := cgroupContainer(instance.Cgroup)
+		return container, container != ""
+	}
+}
+
+// parseGroupBy returns categories of groupings enabled by comma separated list of their modes
+func parseGroupBy(groupBy string) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, mode := range splitList(groupBy) {
+		found := false
+		for category, grouping := range groupings {
+			if grouping.mode == mode {
+				enabled[category] = true
+				found = true
+			}
+		}
+		if !found {
+			modes := []string{}
+			for _, grouping := range groupings {
+				modes = append(modes, grouping.mode)
+			}
+			return nil, fmt.Errorf("unknown grouping %q, expected some of %s", mode, strings.Join(modes, ", "))
+		}
+	}
+	return enabled, nil
+}
+
+// aggregateGroups returns metrics of process instances aggregated by group assigned by key,
+// ps_count holds number of processes in the group
+func aggregateGroups(stats map[string]map[int]Proc, procMetrics map[int]map[string]interface{}, key groupKey) map[string]map[string]interface{} {
+	groups := map[string]map[string]interface{}{}
+	for _, process := range stats {
+		for pid, instance := range process {
+			group, ok := key(instance)
+			if !ok {
+				continue
+			}
+			aggregated, ok := groups[group]
+			if !ok {
+				aggregated = map[string]interface{}{"ps_count": uint64(0)}
+				groups[group] = aggregated
+			}
+			aggregateMetrics(aggregated, procMetrics[pid])
+			aggregated["ps_count"] = aggregated["ps_count"].(uint64) + 1
+		}
+	}
+	return groups
+}
+
+// isGrouping tells if category of namespace holds metrics aggregated over groups of processes
+func isGrouping(category string) bool {
+	_, ok := groupings[category]
+	return ok
+}
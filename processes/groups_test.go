@@ -0,0 +1,144 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserKey(t *testing.T) {
+
+	passwd := map[int]string{0: "root", 1000: "build"}
+	instance := makeMockProc("sudo", 100)
+	instance.Uids = []int{1000, 0, 0, 0}
+
+	Convey("when processes are grouped by effective UID", t, func() {
+		group, ok := userKey(passwd, uidEffective)(instance)
+
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "root")
+	})
+
+	Convey("when processes are grouped by real UID", t, func() {
+		group, ok := userKey(passwd, uidReal)(instance)
+
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "build")
+	})
+
+	Convey("when user is missing in passwd", t, func() {
+		group, ok := userKey(map[int]string{}, uidReal)(instance)
+
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "1000")
+	})
+
+	Convey("when owner of the process is unknown", t, func() {
+		instance.Uids = nil
+		_, ok := userKey(passwd, uidEffective)(instance)
+
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestCgroupKey(t *testing.T) {
+
+	instance := makeMockProc("nginx", 100)
+	instance.Cgroup = "/system.slice/nginx.service/worker"
+
+	Convey("when cgroup path is truncated", t, func() {
+		group, ok := cgroupKey(2)(instance)
+
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "system.slice.nginx.service")
+	})
+
+	Convey("when cgroup path is not truncated", t, func() {
+		group, ok := cgroupKey(0)(instance)
+
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "system.slice.nginx.service.worker")
+	})
+
+	Convey("when cgroup of the process is unknown", t, func() {
+		instance.Cgroup = ""
+		_, ok := cgroupKey(2)(instance)
+
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestAggregateGroups(t *testing.T) {
+
+	Convey("when processes are assigned to groups", t, func() {
+		stats := map[string]map[int]Proc{
+			"bash": map[int]Proc{1: Proc{Pid: 1}, 2: Proc{Pid: 2}},
+			"make": map[int]Proc{3: Proc{Pid: 3}, 4: Proc{Pid: 4}},
+		}
+		procMetrics := map[int]map[string]interface{}{
+			1: map[string]interface{}{"ps_vm": uint64(100), "ps_threads": uint64(1), "ps_cmdline": "bash"},
+			2: map[string]interface{}{"ps_vm": uint64(200), "ps_threads": uint64(1), "ps_cmdline": "bash"},
+			3: map[string]interface{}{"ps_vm": uint64(300), "ps_threads": uint64(4), "ps_cmdline": "make"},
+			4: map[string]interface{}{"ps_vm": uint64(400), "ps_threads": uint64(2), "ps_cmdline": "make"},
+		}
+		// odd processes belong to the first group, process 4 has no group
+		key := func(instance Proc) (string, bool) {
+			switch instance.Pid {
+			case 1, 3:
+				return "odd", true
+			case 2:
+				return "even", true
+			}
+			return "", false
+		}
+		groups := aggregateGroups(stats, procMetrics, key)
+
+		So(groups, ShouldHaveLength, 2)
+		So(groups["odd"], ShouldResemble, map[string]interface{}{"ps_vm": uint64(400), "ps_threads": uint64(5), "ps_count": uint64(2)})
+		So(groups["even"], ShouldResemble, map[string]interface{}{"ps_vm": uint64(200), "ps_threads": uint64(1), "ps_count": uint64(1)})
+	})
+}
+
+func TestParseGroupBy(t *testing.T) {
+
+	Convey("when groupings are enabled by their modes", t, func() {
+		enabled, err := parseGroupBy("user, systemd_unit")
+
+		So(err, ShouldBeNil)
+		So(enabled, ShouldResemble, map[string]bool{groupUser: true, groupUnit: true})
+	})
+
+	Convey("when no grouping is enabled", t, func() {
+		enabled, err := parseGroupBy("")
+
+		So(err, ShouldBeNil)
+		So(enabled, ShouldBeEmpty)
+	})
+
+	Convey("when unknown grouping is given", t, func() {
+		_, err := parseGroupBy("user,unit")
+
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,240 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Sources of process names selected by name_source config
+	nameComm        = "comm"        // comm from /proc/<pid>/stat
+	nameExe         = "exe"         // basename of /proc/<pid>/exe target
+	nameArgv0       = "argv0"       // basename of argv[0]
+	nameInterpreter = "interpreter" // script or java program name, argv[0] for other processes
+
+	// deletedSuffix is appended to /proc/<pid>/exe target when executable was removed
+	deletedSuffix = " (deleted)"
+
+	// Separators of group_rules config, e.g. "web=nginx|php-fpm;etl-${1}=etl_worker\.py --queue=(\w+)"
+	groupRulesSep = ";"
+	groupNameSep  = "="
+)
+
+var (
+	// nameSourceFiles maps sources of process names to files of /proc/<pid> they are read from,
+	// comm is read from stat which is always available
+	nameSourceFiles = map[string]string{
+		nameComm:        "",
+		nameExe:         procExe,
+		nameArgv0:       procCmd,
+		nameInterpreter: procCmd,
+	}
+
+	// scriptInterpreters matches names of interpreters whose processes are named after the script they run
+	scriptInterpreters = regexp.MustCompile(`^(python|perl|ruby|node|nodejs)[0-9.]*$`)
+
+	// scriptOptionsWithValue are options of script interpreters followed by a separate value
+	scriptOptionsWithValue = map[string]bool{
+		"-W":        true,
+		"-X":        true,
+		"-I":        true,
+		"-C":        true,
+		"-r":        true,
+		"--require": true,
+	}
+
+	// scriptInlineOptions are options of script interpreters running code given on command line
+	scriptInlineOptions = map[string]bool{
+		"-c":      true,
+		"-e":      true,
+		"-E":      true,
+		"--eval":  true,
+		"--print": true,
+	}
+
+	// javaOptionsWithValue are options of java followed by a separate value
+	javaOptionsWithValue = map[string]bool{
+		"-cp":                   true,
+		"-classpath":            true,
+		"--class-path":          true,
+		"-p":                    true,
+		"--module-path":         true,
+		"--upgrade-module-path": true,
+		"--add-modules":         true,
+		"--add-opens":           true,
+		"--add-exports":         true,
+		"--add-reads":           true,
+		"--limit-modules":       true,
+		"--patch-module":        true,
+	}
+)
+
+// groupRule assigns processes with command line matching pattern to group named after template,
+// template may refer to capture groups of pattern, e.g. $1 or ${1}
+type groupRule struct {
+	template string
+	pattern  *regexp.Regexp
+}
+
+// parseGroupRules parses ordered list of group rules separated by semicolons,
+// each rule is group name template followed by equals sign and regular expression
+func parseGroupRules(rules string) ([]groupRule, error) {
+	groupRules := []groupRule{}
+	for _, rule := range strings.Split(rules, groupRulesSep) {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		// regular expression may contain equals sign so only the first one separates group name
+		sep := strings.Index(rule, groupNameSep)
+		if sep < 0 {
+			return nil, fmt.Errorf("rule %q does not match format name=regex", rule)
+		}
+		template := strings.TrimSpace(rule[:sep])
+		if template == "" {
+			return nil, fmt.Errorf("rule %q has empty group name", rule)
+		}
+		pattern, err := regexp.Compile(rule[sep+1:])
+		if err != nil {
+			return nil, fmt.Errorf("rule %q has invalid regular expression: %v", rule, err)
+		}
+		groupRules = append(groupRules, groupRule{template: template, pattern: pattern})
+	}
+	return groupRules, nil
+}
+
+// groupName returns name of the group assigned by the first rule matching cmdLine,
+// characters not allowed in process name (e.g. path separators in captured values) are replaced;
+// empty string is returned when none of rules matches
+func groupName(rules []groupRule, cmdLine string) string {
+	for _, rule := range rules {
+		if match := rule.pattern.FindStringSubmatchIndex(cmdLine); match != nil {
+			return removeUnwantedChars(string(rule.pattern.ExpandString(nil, rule.template, cmdLine, match)))
+		}
+	}
+	return ""
+}
+
+// processName returns name of the process taken from nameSource,
+// comm is used when the name cannot be determined (e.g. for kernel threads)
+// and PID when comm consists only of unwanted characters (e.g. [])
+func processName(instance Proc, nameSource string) string {
+	name := ""
+	switch nameSource {
+	case nameComm:
+	case nameExe:
+		if instance.Exe != "" {
+			name = filepath.Base(strings.TrimSuffix(instance.Exe, deletedSuffix))
+		}
+	case nameInterpreter:
+		name = interpretedName(instance.Args)
+		if name == "" {
+			name = argv0Name(instance.CmdLine)
+		}
+	default:
+		name = argv0Name(instance.CmdLine)
+	}
+	if name == "" {
+		// Kernel processes - no command line
+		name = removeUnwantedChars(instance.Stat.Comm)
+	}
+	if name == "" {
+		name = strconv.Itoa(instance.Pid)
+	}
+	return name
+}
+
+// argv0Name returns process name extracted from command line path
+func argv0Name(cmdLine string) string {
+	argv0 := strings.Split(cmdLine, " ")[0]
+	if argv0 == "" {
+		return ""
+	}
+	return filepath.Base(argv0)
+}
+
+// interpretedName returns name of the script run by python, perl, ruby or node
+// and name of the jar or main class run by java, empty string is returned for other processes
+func interpretedName(args []string) string {
+	if len(args) == 0 || args[0] == "" {
+		return ""
+	}
+	interpreter := filepath.Base(args[0])
+	if scriptInterpreters.MatchString(interpreter) {
+		return scriptName(args[1:])
+	}
+	if interpreter == "java" {
+		return javaProgramName(args[1:])
+	}
+	return ""
+}
+
+// scriptName returns name of the script or module from arguments of script interpreter,
+// empty string is returned when code is given on command line or read from stdin
+func scriptName(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-m" && i+1 < len(args): // python module
+			return args[i+1]
+		case scriptInlineOptions[arg]:
+			return ""
+		case arg == "--":
+			if i+1 < len(args) {
+				return filepath.Base(args[i+1])
+			}
+			return ""
+		case scriptOptionsWithValue[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return filepath.Base(arg)
+		}
+	}
+	return ""
+}
+
+// javaProgramName returns name of the jar, main class or main class of module from arguments of java
+func javaProgramName(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-jar" && i+1 < len(args):
+			return filepath.Base(args[i+1])
+		case (arg == "-m" || arg == "--module") && i+1 < len(args):
+			// module may be followed by its main class, e.g. -m module/package.Main
+			module := args[i+1]
+			if slash := strings.LastIndex(module, "/"); slash >= 0 && slash < len(module)-1 {
+				return module[slash+1:]
+			}
+			return module
+		case javaOptionsWithValue[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return arg
+		}
+	}
+	return ""
+}
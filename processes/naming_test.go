@@ -0,0 +1,157 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProcessName(t *testing.T) {
+
+	mockNamed := func(comm, exe string, args ...string) Proc {
+		proc := Proc{Args: args, Exe: exe}
+		proc.Stat.Comm = comm
+		for i, arg := range args {
+			if i > 0 {
+				proc.CmdLine += " "
+			}
+			proc.CmdLine += arg
+		}
+		return proc
+	}
+
+	Convey("when process is named after comm", t, func() {
+		proc := mockNamed("python3", "/usr/bin/python3.11", "/usr/bin/python3", "etl_worker.py")
+
+		So(processName(proc, nameComm), ShouldEqual, "python3")
+	})
+
+	Convey("when process is named after executable", t, func() {
+		So(processName(mockNamed("python3", "/usr/bin/python3.11", "/usr/bin/python3"), nameExe), ShouldEqual, "python3.11")
+		So(processName(mockNamed("nginx", "/usr/sbin/nginx (deleted)", "nginx: worker process"), nameExe), ShouldEqual, "nginx")
+		// executable of kernel threads is not available
+		So(processName(mockNamed("kworker/0:1", ""), nameExe), ShouldEqual, "kworker.0:1")
+	})
+
+	Convey("when process is named after argv[0]", t, func() {
+		So(processName(mockNamed("python3", "/usr/bin/python3.11", "/usr/bin/python3", "etl_worker.py"), nameArgv0), ShouldEqual, "python3")
+		So(processName(mockNamed("python3", "/usr/bin/python3.11", "/usr/bin/python3", "etl_worker.py"), ""), ShouldEqual, "python3")
+		So(processName(mockNamed("kworker/0:1", ""), nameArgv0), ShouldEqual, "kworker.0:1")
+	})
+
+	Convey("when process is named after interpreted program", t, func() {
+		cases := []struct {
+			args     []string
+			expected string
+		}{
+			{[]string{"/usr/bin/python3", "-u", "/opt/etl/etl_worker.py", "--queue=orders"}, "etl_worker.py"},
+			{[]string{"python3.11", "-W", "ignore", "-m", "http.server", "8080"}, "http.server"},
+			{[]string{"python", "-c", "print(1)"}, "python"},
+			{[]string{"python"}, "python"},
+			{[]string{"/usr/bin/perl", "-w", "/usr/sbin/munin-node"}, "munin-node"},
+			{[]string{"ruby", "-I", "lib", "bin/rails", "server"}, "rails"},
+			{[]string{"node", "--max-old-space-size=4096", "/srv/app/server.js"}, "server.js"},
+			{[]string{"nodejs", "-e", "setInterval(() => {}, 1000)"}, "nodejs"},
+			{[]string{"/usr/lib/jvm/bin/java", "-Xmx2g", "-cp", "/opt/lib/*", "org.example.Main", "run"}, "org.example.Main"},
+			{[]string{"java", "-Dfoo=bar", "-jar", "/opt/kafka/kafka.jar"}, "kafka.jar"},
+			{[]string{"java", "-p", "mods", "-m", "org.example.app/org.example.app.Main"}, "org.example.app.Main"},
+			{[]string{"java", "--module", "org.example.app"}, "org.example.app"},
+			{[]string{"java", "-version"}, "java"},
+			{[]string{"/usr/sbin/sshd", "-D"}, "sshd"},
+		}
+
+		for _, c := range cases {
+			So(processName(mockNamed("comm", "", c.args...), nameInterpreter), ShouldEqual, c.expected)
+		}
+	})
+
+	Convey("when process without command line is named after interpreted program", t, func() {
+		So(processName(mockNamed("kthreadd", ""), nameInterpreter), ShouldEqual, "kthreadd")
+	})
+
+	Convey("when comm consists only of unwanted characters", t, func() {
+		// command line and executable are not available, e.g. not read or not accessible
+		hostile := mockNamed("[]", "")
+		hostile.Pid = 4321
+
+		for _, nameSource := range []string{nameComm, nameExe, nameArgv0, nameInterpreter} {
+			So(processName(hostile, nameSource), ShouldEqual, "4321")
+		}
+
+		Convey("name taken from available source is used", func() {
+			named := mockNamed("()", "/usr/local/bin/()", "/usr/local/bin/()")
+			named.Pid = 4321
+
+			So(processName(named, nameComm), ShouldEqual, "4321")
+			So(processName(named, nameExe), ShouldEqual, "()")
+			So(processName(named, nameArgv0), ShouldEqual, "()")
+			So(processName(named, nameInterpreter), ShouldEqual, "()")
+		})
+	})
+}
+
+func TestGroupRules(t *testing.T) {
+
+	Convey("when group rules are valid", t, func() {
+		rules, err := parseGroupRules(`web=^/usr/sbin/(nginx|php-fpm);etl-worker-${1}=python .*etl_worker\.py --queue=(\w+); ;db=postgres`)
+
+		So(err, ShouldBeNil)
+		So(rules, ShouldHaveLength, 3)
+
+		Convey("process is assigned to the first matching group", func() {
+			So(groupName(rules, "/usr/sbin/nginx -g daemon off;"), ShouldEqual, "web")
+			So(groupName(rules, "/usr/sbin/php-fpm: pool www"), ShouldEqual, "web")
+			So(groupName(rules, "/usr/bin/python /opt/etl_worker.py --queue=orders --db=postgres"), ShouldEqual, "etl-worker-orders")
+			So(groupName(rules, "postgres: checkpointer"), ShouldEqual, "db")
+		})
+
+		Convey("process not matching any group is not assigned", func() {
+			So(groupName(rules, "/usr/sbin/sshd -D"), ShouldBeEmpty)
+			So(groupName(rules, ""), ShouldBeEmpty)
+		})
+	})
+
+	Convey("when group name expands to characters not allowed in process name", t, func() {
+		rules, err := parseGroupRules(`java-${1}=java -jar (\S+)`)
+
+		So(err, ShouldBeNil)
+		So(groupName(rules, "java -jar /opt/app/[billing].jar"), ShouldEqual, "java-.opt.app.billing.jar")
+	})
+
+	Convey("when group rules are empty", t, func() {
+		rules, err := parseGroupRules("")
+
+		So(err, ShouldBeNil)
+		So(rules, ShouldBeEmpty)
+		So(groupName(rules, "/usr/sbin/nginx"), ShouldBeEmpty)
+	})
+
+	Convey("when group rules are invalid", t, func() {
+		for _, rules := range []string{"nginx", "=nginx", "web=(nginx", "web=nginx;db"} {
+			_, err := parseGroupRules(rules)
+
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
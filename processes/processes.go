@@ -21,12 +21,16 @@ package processes
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -46,10 +50,61 @@ const (
 	nsStateName = 4 // /intel/procfs/processes/states/->StateName<-
 	nsPid       = 5 // /intel/procfs/processes/process/ProcName/->Pid<-
 	nsPsCount   = 5 // /intel/procfs/processes/process/ProcName/->ps_count<-
+	nsCollector = 4 // /intel/procfs/processes/collector/->metric<-
 	nsPidMetric = 6 // /intel/procfs/processes/process/ProcName/Pid/->metric<-
+	nsThread    = 6 // /intel/procfs/processes/process/ProcName/Pid/->thread<-
+	nsTid       = 7 // /intel/procfs/processes/process/ProcName/Pid/thread/->Tid<-
+	nsTidMetric = 8 // /intel/procfs/processes/process/ProcName/Pid/thread/Tid/->metric<-
+
+	nsGroup       = 4 // /intel/procfs/processes/Category/->Group<-, e.g. /intel/procfs/processes/user/->UserName<-
+	nsGroupMetric = 5 // /intel/procfs/processes/Category/Group/->metric<-
+
+	// Aggregation functions of process instances metrics, sum is used when not specified
+	aggrMin  = "min"
+	aggrMax  = "max"
+	aggrNone = "none" // metric is not aggregated, e.g. string
+
+	// selfStatusPath is status of the plugin process, it holds resident set size of the plugin
+	selfStatusPath = "/proc/self/status"
 )
 
 var (
+	// pageSize is size of memory page in bytes, used to convert RSS from pages
+	pageSize = uint64(os.Getpagesize())
+
+	// statusMemory maps memory fields of /proc/<pid>/status (in kB) to metric names
+	statusMemory = map[string]string{
+		"VmPeak":   "ps_vm_peak",
+		"VmHWM":    "ps_vm_hwm",
+		"VmSwap":   "ps_vm_swap",
+		"VmLck":    "ps_vm_lck",
+		"VmPin":    "ps_vm_pin",
+		"VmPTE":    "ps_vm_pte",
+		"RssAnon":  "ps_rss_anon",
+		"RssFile":  "ps_rss_file",
+		"RssShmem": "ps_rss_shmem",
+	}
+
+	// resourceLimits lists limits of /proc/<pid>/limits exposed as ps_limit_* metrics,
+	// open files limit is exposed as ps_fd_limit_* metrics
+	resourceLimits = []resourceLimit{
+		{name: "Max cpu time", metric: "ps_limit_cpu_time", description: "CPU time", unit: "s"},
+		{name: "Max file size", metric: "ps_limit_file_size", description: "size of files the process may create", unit: "B"},
+		{name: "Max data size", metric: "ps_limit_data_size", description: "size of data segment", unit: "B"},
+		{name: "Max stack size", metric: "ps_limit_stack_size", description: "size of stack", unit: "B"},
+		{name: "Max core file size", metric: "ps_limit_core_file_size", description: "size of core file", unit: "B"},
+		{name: "Max resident set", metric: "ps_limit_resident_set", description: "resident set size", unit: "B"},
+		{name: "Max processes", metric: "ps_limit_processes", description: "number of processes of the user"},
+		{name: "Max locked memory", metric: "ps_limit_locked_memory", description: "size of memory locked in RAM", unit: "B"},
+		{name: "Max address space", metric: "ps_limit_address_space", description: "size of virtual memory", unit: "B"},
+		{name: "Max file locks", metric: "ps_limit_file_locks", description: "number of file locks"},
+		{name: "Max pending signals", metric: "ps_limit_pending_signals", description: "number of signals queued for the user"},
+		{name: "Max msgqueue size", metric: "ps_limit_msgqueue_size", description: "size of POSIX message queues of the user", unit: "B"},
+		{name: "Max nice priority", metric: "ps_limit_nice_priority", description: "nice priority, 20 - nice"},
+		{name: "Max realtime priority", metric: "ps_limit_realtime_priority", description: "real-time priority"},
+		{name: "Max realtime timeout", metric: "ps_limit_realtime_timeout", description: "CPU time of real-time process without blocking", unit: "us"},
+	}
+
 	metricNames = map[string]label{
 		"ps_vm": label{
 			category:    "pid",
@@ -60,15 +115,106 @@ var (
 			category:    "pid",
 			description: "Resident Set Size: number of pages the process has in real memory",
 		},
+		"ps_rss_bytes": label{
+			category:    "pid",
+			description: "Resident Set Size: amount of memory the process has in real memory",
+			unit:        "B",
+		},
+		"ps_vm_peak": label{
+			category:    "pid",
+			description: "Peak virtual memory size",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_vm_hwm": label{
+			category:    "pid",
+			description: "Peak resident set size (high water mark)",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_vm_swap": label{
+			category:    "pid",
+			description: "Swapped-out virtual memory size by anonymous private pages",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_vm_lck": label{
+			category:    "pid",
+			description: "Locked memory size",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_vm_pin": label{
+			category:    "pid",
+			description: "Pinned memory size, pages which cannot be moved",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_vm_pte": label{
+			category:    "pid",
+			description: "Size of page table entries",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_rss_anon": label{
+			category:    "pid",
+			description: "Size of resident anonymous memory",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_rss_file": label{
+			category:    "pid",
+			description: "Size of resident file mappings",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_rss_shmem": label{
+			category:    "pid",
+			description: "Size of resident shared memory",
+			unit:        "B",
+			sources:     []string{procStatus},
+		},
+		"ps_pss": label{
+			category:    "pid",
+			description: "Proportional Set Size: resident memory with pages shared with other processes divided by number of sharing processes",
+			unit:        "B",
+			sources:     []string{procSmaps},
+		},
+		"ps_uss": label{
+			category:    "pid",
+			description: "Unique Set Size: resident memory private to the process",
+			unit:        "B",
+			sources:     []string{procSmaps},
+		},
+		"ps_swap_pss": label{
+			category:    "pid",
+			description: "Proportional swap size: swapped-out memory with pages shared with other processes divided by number of sharing processes",
+			unit:        "B",
+			sources:     []string{procSmaps},
+		},
+		"ps_shared_clean": label{
+			category:    "pid",
+			description: "Size of clean resident memory shared with other processes",
+			unit:        "B",
+			sources:     []string{procSmaps},
+		},
+		"ps_shared_dirty": label{
+			category:    "pid",
+			description: "Size of dirty resident memory shared with other processes",
+			unit:        "B",
+			sources:     []string{procSmaps},
+		},
 		"ps_data": label{
 			category:    "pid",
 			description: "Size of data segments",
 			unit:        "B",
+			sources:     []string{procStatus},
 		},
 		"ps_code": label{
 			category:    "pid",
 			description: "Size of text segment",
 			unit:        "B",
+			sources:     []string{procStatus},
 		},
 		"ps_stacksize": label{
 			category:    "pid",
@@ -85,6 +231,31 @@ var (
 			description: "Amount of time that this process has been scheduled in kernel mode",
 			unit:        "Jiff",
 		},
+		"ps_cputime_user_seconds": label{
+			category:    "pid",
+			description: "Amount of time that this process has been scheduled in user mode",
+			unit:        "s",
+		},
+		"ps_cputime_system_seconds": label{
+			category:    "pid",
+			description: "Amount of time that this process has been scheduled in kernel mode",
+			unit:        "s",
+		},
+		"ps_cpu_percent_user": label{
+			category:    "pid",
+			description: "Percentage of time that this process has been scheduled in user mode since previous collection",
+			unit:        "%",
+		},
+		"ps_cpu_percent_system": label{
+			category:    "pid",
+			description: "Percentage of time that this process has been scheduled in kernel mode since previous collection",
+			unit:        "%",
+		},
+		"ps_cpu_percent_total": label{
+			category:    "pid",
+			description: "Percentage of time that this process has been scheduled in user and kernel mode since previous collection",
+			unit:        "%",
+		},
 		"ps_pagefaults_min": label{
 			category:    "pid",
 			description: "The number of minor faults the process has made",
@@ -96,24 +267,166 @@ var (
 		"ps_disk_ops_syscr": label{
 			category:    "pid",
 			description: "Attempt to count the number of read I/O operations",
+			sources:     []string{procIO},
 		},
 		"ps_disk_ops_syscw": label{
 			category:    "pid",
 			description: "Attempt to count the number of write I/O operations",
+			sources:     []string{procIO},
 		},
 		"ps_disk_octets_rchar": label{
 			category:    "pid",
 			description: "The number of bytes which this task has caused to be read from storage",
 			unit:        "B",
+			sources:     []string{procIO},
 		},
 		"ps_disk_octets_wchar": label{
 			category:    "pid",
 			description: "The number of bytes which this task has caused, or shall cause to be written to disk",
 			unit:        "B",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_read_bytes": label{
+			category:    "pid",
+			description: "The number of bytes which this task has caused to be fetched from the storage layer",
+			unit:        "B",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_write_bytes": label{
+			category:    "pid",
+			description: "The number of bytes which this task has caused to be sent to the storage layer",
+			unit:        "B",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_cancelled_write_bytes": label{
+			category:    "pid",
+			description: "The number of bytes which this task has caused not to be written to the storage layer by truncating page cache",
+			unit:        "B",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_read_bytes_rate": label{
+			category:    "pid",
+			description: "The number of bytes per second which this task has caused to be fetched from the storage layer since previous collection",
+			unit:        "B/s",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_write_bytes_rate": label{
+			category:    "pid",
+			description: "The number of bytes per second which this task has caused to be sent to the storage layer since previous collection",
+			unit:        "B/s",
+			sources:     []string{procIO},
+		},
+		"ps_disk_octets_cancelled_write_bytes_rate": label{
+			category:    "pid",
+			description: "The number of bytes per second which this task has caused not to be written to the storage layer since previous collection",
+			unit:        "B/s",
+			sources:     []string{procIO},
 		},
 		"ps_cmdline": label{
 			category:    "pid",
 			description: "Process command line with arguments",
+			aggregation: aggrNone,
+			sources:     []string{procCmd},
+		},
+		"ps_container_id": label{
+			category:    "pid",
+			description: "Identifier of the container of the process (docker, containerd, cri-o or podman)",
+			aggregation: aggrNone,
+			sources:     []string{procCgroup},
+		},
+		"ps_pod_uid": label{
+			category:    "pid",
+			description: "UID of the Kubernetes pod of the process",
+			aggregation: aggrNone,
+			sources:     []string{procCgroup},
+		},
+
+		"ps_ctxt_switches_voluntary": label{
+			category:    "pid",
+			description: "Number of voluntary context switches, process gave up CPU waiting for a resource",
+			sources:     []string{procStatus},
+		},
+		"ps_ctxt_switches_nonvoluntary": label{
+			category:    "pid",
+			description: "Number of involuntary context switches, process was preempted by the scheduler",
+			sources:     []string{procStatus},
+		},
+		"ps_ctxt_switches_voluntary_rate": label{
+			category:    "pid",
+			description: "Number of voluntary context switches per second since previous collection",
+			unit:        "1/s",
+			sources:     []string{procStatus},
+		},
+		"ps_ctxt_switches_nonvoluntary_rate": label{
+			category:    "pid",
+			description: "Number of involuntary context switches per second since previous collection",
+			unit:        "1/s",
+			sources:     []string{procStatus},
+		},
+		"ps_fd_count": label{
+			category:    "pid",
+			description: "Number of open file descriptors",
+			sources:     []string{procFd},
+		},
+		"ps_fd_limit_soft": label{
+			category:    "pid",
+			description: "Soft limit of open file descriptors, 18446744073709551615 means unlimited",
+			aggregation: aggrMin,
+			sources:     []string{procLimits},
+		},
+		"ps_fd_limit_hard": label{
+			category:    "pid",
+			description: "Hard limit of open file descriptors, 18446744073709551615 means unlimited",
+			aggregation: aggrMin,
+			sources:     []string{procLimits},
+		},
+		"ps_fd_utilization": label{
+			category:    "pid",
+			description: "Ratio of open file descriptors to their soft limit",
+			aggregation: aggrMax,
+			sources:     []string{procFd, procLimits},
+		},
+		"ps_threads": label{
+			category:    "pid",
+			description: "Number of threads of the process",
+		},
+		"ps_ppid": label{
+			category:    "pid",
+			description: "Identifier of the parent process",
+			aggregation: aggrNone,
+		},
+
+		"thread_name": label{
+			category:    "thread",
+			description: "Name of the thread",
+			sources:     []string{procTask},
+		},
+		"thread_state": label{
+			category:    "thread",
+			description: "State of the thread",
+			sources:     []string{procTask},
+		},
+		"thread_cputime_user": label{
+			category:    "thread",
+			description: "Amount of time that this thread has been scheduled in user mode",
+			unit:        "Jiff",
+			sources:     []string{procTask},
+		},
+		"thread_cputime_system": label{
+			category:    "thread",
+			description: "Amount of time that this thread has been scheduled in kernel mode",
+			unit:        "Jiff",
+			sources:     []string{procTask},
+		},
+		"thread_ctxt_switches_voluntary": label{
+			category:    "thread",
+			description: "Number of voluntary context switches of the thread",
+			sources:     []string{procTask},
+		},
+		"thread_ctxt_switches_nonvoluntary": label{
+			category:    "thread",
+			description: "Number of involuntary context switches of the thread",
+			sources:     []string{procTask},
 		},
 
 		"ps_count": label{
@@ -121,6 +434,45 @@ var (
 			description: "Number of process instances",
 		},
 
+		"reads_denied": label{
+			category:    "collector",
+			description: "Number of procfs files which could not be read due to insufficient permissions in the last collection",
+		},
+		"collection_duration": label{
+			category:    "collector",
+			description: "Duration of the last collection",
+			unit:        "s",
+		},
+		"pids_scanned": label{
+			category:    "collector",
+			description: "Number of PIDs found in procfs in the last collection",
+		},
+		"pids_skipped_vanished": label{
+			category:    "collector",
+			description: "Number of PIDs skipped in the last collection because the process exited during the scan",
+		},
+		"pids_skipped_permission": label{
+			category:    "collector",
+			description: "Number of PIDs skipped in the last collection because their stat could not be read due to insufficient permissions",
+		},
+		"pids_skipped_parse_error": label{
+			category:    "collector",
+			description: "Number of PIDs skipped in the last collection because their stat could not be parsed",
+		},
+		"pids_excluded": label{
+			category:    "collector",
+			description: "Number of PIDs excluded from process metrics by filters in the last collection",
+		},
+		"metrics_emitted": label{
+			category:    "collector",
+			description: "Number of metrics other than collector metrics returned in the last collection",
+		},
+		"rss_bytes": label{
+			category:    "collector",
+			description: "Resident Set Size of the plugin process",
+			unit:        "B",
+		},
+
 		"running": label{
 			category:    "state",
 			description: "Number of processes with 'running' status",
@@ -161,9 +513,37 @@ var (
 			category:    "state",
 			description: "Number of processes with 'parked' status",
 		},
+		"idle": label{
+			category:    "state",
+			description: "Number of processes with 'idle' status",
+		},
+		"unknown": label{
+			category:    "state",
+			description: "Number of processes with status not recognized by the plugin",
+		},
 	}
 )
 
+func init() {
+	// add soft and hard limit metrics for each of resource limits
+	for _, limit := range resourceLimits {
+		metricNames[limit.metric+"_soft"] = label{
+			category:    "pid",
+			description: "Soft limit of " + limit.description + ", 18446744073709551615 means unlimited",
+			unit:        limit.unit,
+			aggregation: aggrMin,
+			sources:     []string{procLimits},
+		}
+		metricNames[limit.metric+"_hard"] = label{
+			category:    "pid",
+			description: "Hard limit of " + limit.description + ", 18446744073709551615 means unlimited",
+			unit:        limit.unit,
+			aggregation: aggrMin,
+			sources:     []string{procLimits},
+		}
+	}
+}
+
 // New returns instance of processes plugin
 func New() *procPlugin {
 	host, err := os.Hostname()
@@ -171,7 +551,12 @@ func New() *procPlugin {
 		host = "localhost"
 	}
 
-	return &procPlugin{host: host, mc: &procStatsCollector{}}
+	return &procPlugin{
+		host:       host,
+		mc:         &procStatsCollector{},
+		rates:      newRateTracker(),
+		clockTicks: getClockTicks(),
+	}
 }
 
 // Meta returns plugin meta data
@@ -183,6 +568,11 @@ func Meta() []plugin.MetaOpt {
 
 // GetMetricTypes returns list of available metrics
 func (procPlg *procPlugin) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, error) {
+	// only metrics of groupings enabled in plugin config are advertised
+	enabled, err := getGroupBy(cfg)
+	if err != nil {
+		return nil, err
+	}
 	metricTypes := []plugin.Metric{}
 	// build metric types from process metric names
 	for metricName, label := range metricNames {
@@ -199,7 +589,7 @@ func (procPlg *procPlugin) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, e
 			})
 
 			// Aggregated metrics
-			if metricName != "ps_cmdline" {
+			if label.aggregation != aggrNone {
 				metricTypes = append(metricTypes,
 					plugin.Metric{
 						Namespace: plugin.NewNamespace(pluginVendor, fs, PluginName, "process").
@@ -210,7 +600,27 @@ func (procPlg *procPlugin) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, e
 						Description: label.description,
 						Unit:        label.unit,
 					})
+				metricTypes = append(metricTypes, groupMetricTypes(cfg, enabled, metricName, label)...)
 			}
+		case "thread":
+			metricTypes = append(metricTypes, plugin.Metric{
+				Namespace: plugin.NewNamespace(pluginVendor, fs, PluginName, "process").
+					AddDynamicElement("process_name", "name of the process").
+					AddDynamicElement("process_pid", "identifier of the process").
+					AddStaticElement("thread").
+					AddDynamicElement("thread_tid", "identifier of the thread").
+					AddStaticElements(metricName),
+				Config:      cfg,
+				Description: label.description,
+				Unit:        label.unit,
+			})
+		case "collector":
+			metricTypes = append(metricTypes, plugin.Metric{
+				Namespace:   plugin.NewNamespace(pluginVendor, fs, PluginName, "collector", metricName),
+				Config:      cfg,
+				Description: label.description,
+				Unit:        label.unit,
+			})
 		case "state":
 			metricTypes = append(metricTypes, plugin.Metric{
 				Namespace:   plugin.NewNamespace(pluginVendor, fs, PluginName, "state", metricName),
@@ -228,16 +638,53 @@ func (procPlg *procPlugin) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, e
 					Description: label.description,
 					Unit:        label.unit,
 				})
+			metricTypes = append(metricTypes, groupMetricTypes(cfg, enabled, metricName, label)...)
 		}
 	}
 
 	return metricTypes, nil
 }
 
+// groupMetricTypes returns types of metric aggregated over groups of processes for each enabled category of grouping
+func groupMetricTypes(cfg plugin.Config, enabled map[string]bool, metricName string, label label) []plugin.Metric {
+	metricTypes := []plugin.Metric{}
+	for category, grouping := range groupings {
+		if !enabled[category] {
+			continue
+		}
+		metricTypes = append(metricTypes, plugin.Metric{
+			Namespace: plugin.NewNamespace(pluginVendor, fs, PluginName, category).
+				AddDynamicElement(grouping.element, grouping.description).
+				AddStaticElement(metricName),
+			Config:      cfg,
+			Description: label.description,
+			Unit:        label.unit,
+		})
+	}
+	return metricTypes
+}
+
 // GetConfigPolicy returns config policy
 func (procPlg *procPlugin) GetConfigPolicy() (plugin.ConfigPolicy, error) {
 	policy := plugin.NewConfigPolicy()
 	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "proc_path", false, plugin.SetDefaultString("/proc"))
+	policy.AddNewBoolRule([]string{pluginVendor, fs, PluginName}, "normalize_cpu_percent", false, plugin.SetDefaultBool(false))
+	policy.AddNewBoolRule([]string{pluginVendor, fs, PluginName}, "collect_smaps", false, plugin.SetDefaultBool(false))
+	policy.AddNewBoolRule([]string{pluginVendor, fs, PluginName}, "collect_threads", false, plugin.SetDefaultBool(false))
+	policy.AddNewIntRule([]string{pluginVendor, fs, PluginName}, "scan_workers", false, plugin.SetDefaultInt(1), plugin.SetMinInt(1), plugin.SetMaxInt(maxWorkers))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "name_source", false, plugin.SetDefaultString(nameArgv0))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "group_rules", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "include_names", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "exclude_names", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "include_cmdline_regex", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "exclude_users", false, plugin.SetDefaultString(""))
+	policy.AddNewBoolRule([]string{pluginVendor, fs, PluginName}, "exclude_kernel_threads", false, plugin.SetDefaultBool(false))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "user_uid", false, plugin.SetDefaultString(userUIDEffective))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "root_path", false, plugin.SetDefaultString("/"))
+	policy.AddNewIntRule([]string{pluginVendor, fs, PluginName}, "cgroup_depth", false, plugin.SetDefaultInt(defaultCgroupDepth), plugin.SetMinInt(0))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "group_by", false, plugin.SetDefaultString(defaultGroupBy))
+	policy.AddNewStringRule([]string{pluginVendor, fs, PluginName}, "tags", false, plugin.SetDefaultString(""))
+	policy.AddNewIntRule([]string{pluginVendor, fs, PluginName}, "cmdline_tag_max_length", false, plugin.SetDefaultInt(0), plugin.SetMinInt(0))
 	return *policy, nil
 }
 
@@ -248,6 +695,7 @@ type metricKey struct {
 
 // CollectMetrics retrieves values for given metrics types
 func (procPlg *procPlugin) CollectMetrics(metricTypes []plugin.Metric) ([]plugin.Metric, error) {
+	start := time.Now()
 	metrics := []plugin.Metric{}
 	stateCount := map[string]uint64{}
 
@@ -260,84 +708,119 @@ func (procPlg *procPlugin) CollectMetrics(metricTypes []plugin.Metric) ([]plugin
 	for _, state := range States.Values() {
 		stateCount[state] = 0
 	}
+	stateCount[unknownState] = 0
+	opts, err := getStatsOptions(metricTypes)
+	if err != nil {
+		return nil, err
+	}
+	tagger, err := procPlg.getTagger(metricTypes, procPath)
+	if err != nil {
+		return nil, err
+	}
 	// get all proc stats
-	stats, err := procPlg.mc.GetStats(procPath)
+	stats, scan, err := procPlg.mc.GetStats(procPath, opts)
 	if err != nil {
 		return nil, err
 	}
-	// calculate number of processes in each state
+	groupKeys, err := getGroupKeys(metricTypes, stats)
+	if err != nil {
+		return nil, err
+	}
+	// calculate number of processes in each state and number of files which could not be read,
+	// processes excluded by filter are counted in states
+	for state, count := range scan.ExcludedStates {
+		if stateName, ok := States[state]; ok {
+			stateCount[stateName] += count
+		} else {
+			stateCount[unknownState] += count
+		}
+	}
+	readsDenied := uint64(0)
 	for _, process := range stats {
 		for _, instance := range process {
+			for _, err := range instance.Unreadable {
+				if os.IsPermission(err) {
+					readsDenied++
+				}
+			}
 			if stateName, ok := States[instance.State]; ok {
 				stateCount[stateName]++
 			} else {
-				return nil, fmt.Errorf("Cannot find state %s is state map", stateName)
+				log.WithFields(log.Fields{
+					"pid":   instance.Pid,
+					"state": instance.State,
+				}).Debug("Unknown process state")
+				stateCount[unknownState]++
 			}
 		}
 	}
 
-	// calculate metrics
-	aggregated := map[string]map[string]uint64{}
+	cpuCount := 1
+	// CPU utilization normalization is disabled when not configured
+	if normalize, err := metricTypes[0].Config.GetBool("normalize_cpu_percent"); err == nil && normalize {
+		cpuCount = runtime.NumCPU()
+	}
+	// aggregates are calculated only when requested
+	withAggregates := false
+	for _, metricType := range metricTypes {
+		ns := metricType.Namespace
+		if len(ns) == 7 && ns[nsCategory].Value == "process" && ns[nsPid].Value == "all" {
+			withAggregates = true
+		}
+	}
+	// calculate metrics of all processes once, requested metrics are returned from the snapshot
+	snap := procPlg.newSnapshot(stats, scan.PIDs, cpuCount, withAggregates, groupKeys, time.Now())
+
+	// return metrics, collector metrics are calculated at the end as they describe the whole collection
 	processCount := map[string]uint64{}
+	for processName, process := range stats {
+		processCount[processName] = uint64(len(process))
+	}
+	collectorMts := []plugin.Namespace{}
+	// per-process metrics are returned in a single pass over processes after other metrics
+	pidMts := []plugin.Namespace{}
 	for _, metricType := range metricTypes {
 		ns := metricType.Namespace
-		if len(ns) == 7 && ns[nsCategory].Value == "process" { // process metrics
+		if len(ns) == 7 && ns[nsCategory].Value == "process" && ns[nsPid].Value != "all" { // process metrics
+			pidMts = append(pidMts, ns)
+		} else if len(ns) == 7 && ns[nsCategory].Value == "process" { // aggregated process metrics
 			reqProcName := ns[nsProcName].Value
-			reqProcPID := ns[nsPid].Value
 			metricName := ns[nsPidMetric].Value
 
-			// return per-process metrics and build aggregated data map
-			for processName, process := range stats {
-				aggregated[processName] = map[string]uint64{}
-
-				for processPid, instance := range process {
-					if processName == reqProcName || reqProcName == "*" {
-						if strconv.Itoa(processPid) == reqProcPID || reqProcPID == "*" {
-							procMetrics, err := setProcMetrics(instance)
-							if err != nil {
-								return nil, fmt.Errorf("Error setting metric data: %v", err)
-							}
-							nuns := append([]plugin.NamespaceElement{}, ns...)
-							nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
-							nuns[nsPid] = fillNsElement(&nuns[nsPid], strconv.Itoa(processPid))
-
-							metric := plugin.Metric{
-								Namespace:   nuns,
-								Data:        procMetrics[metricName],
-								Timestamp:   time.Now(),
-								Unit:        metricNames[metricName].unit,
-								Description: metricNames[metricName].description,
-							}
-							metrics = append(metrics, metric)
-						}
-
-						if reqProcPID == "all" {
-							procMetrics, err := setProcMetrics(instance)
-							if err != nil {
-								return nil, fmt.Errorf("Error setting metric data: %v", err)
-							}
-							for procMetricName, val := range procMetrics {
-								if valInt, ok := val.(uint64); ok {
-									aggregated[processName][procMetricName] += valInt
-								}
-							}
-						}
-					}
+			for processName := range stats {
+				if processName != reqProcName && reqProcName != "*" {
+					continue
+				}
+				if data, ok := snap.aggregated[processName][metricName]; ok {
+					nuns := append([]plugin.NamespaceElement{}, ns...)
+					nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
+					nuns[nsPid] = fillNsElement(&nuns[nsPid], "all")
+					metrics = append(metrics, prepareMetric(nuns, metricName, data))
 				}
 			}
+		} else if len(ns) == 9 && ns[nsCategory].Value == "process" && ns[nsThread].Value == "thread" { // thread metrics
+			reqProcName := ns[nsProcName].Value
+			reqProcPID := ns[nsPid].Value
+			reqTid := ns[nsTid].Value
+			metricName := ns[nsTidMetric].Value
 
-			// return aggregated metrics
-			if reqProcPID == "all" {
-				for aggrProcessName, aggrMetric := range aggregated {
-					for aggrMetricName, aggrData := range aggrMetric {
-						if reqProcName == aggrProcessName || reqProcName == "*" {
-							if metricName == aggrMetricName {
-								nuns := append([]plugin.NamespaceElement{}, ns...)
-								nuns[nsProcName] = fillNsElement(&nuns[nsProcName], aggrProcessName)
-								nuns[nsPid] = fillNsElement(&nuns[nsPid], "all")
-								metrics = append(metrics, prepareMetric(nuns, metricName, aggrData))
-							}
+			for processName, process := range stats {
+				if processName != reqProcName && reqProcName != "*" {
+					continue
+				}
+				for processPid, instance := range process {
+					if strconv.Itoa(processPid) != reqProcPID && reqProcPID != "*" {
+						continue
+					}
+					for tid, thread := range instance.Threads {
+						if strconv.Itoa(tid) != reqTid && reqTid != "*" {
+							continue
 						}
+						nuns := append([]plugin.NamespaceElement{}, ns...)
+						nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
+						nuns[nsPid] = fillNsElement(&nuns[nsPid], strconv.Itoa(processPid))
+						nuns[nsTid] = fillNsElement(&nuns[nsTid], strconv.Itoa(tid))
+						metrics = append(metrics, prepareMetric(nuns, metricName, setThreadMetrics(thread)[metricName]))
 					}
 				}
 			}
@@ -345,10 +828,6 @@ func (procPlg *procPlugin) CollectMetrics(metricTypes []plugin.Metric) ([]plugin
 			reqProcName := ns[nsProcName].Value
 			metricName := ns[nsPsCount].Value
 
-			for processName, process := range stats {
-				processCount[processName] = uint64(len(process))
-			}
-
 			// return process count metric
 			for processName, processCount := range processCount {
 				if reqProcName == processName || reqProcName == "*" {
@@ -358,6 +837,20 @@ func (procPlg *procPlugin) CollectMetrics(metricTypes []plugin.Metric) ([]plugin
 					metrics = append(metrics, prepareMetric(nuns, metricName, processCount))
 				}
 			}
+		} else if len(ns) == 6 && isGrouping(ns[nsCategory].Value) { // metrics aggregated by group
+			reqGroup := ns[nsGroup].Value
+			metricName := ns[nsGroupMetric].Value
+
+			for group, aggregated := range snap.groups[ns[nsCategory].Value] {
+				if group != reqGroup && reqGroup != "*" {
+					continue
+				}
+				if data, ok := aggregated[metricName]; ok {
+					nuns := append([]plugin.NamespaceElement{}, ns...)
+					nuns[nsGroup] = fillNsElement(&nuns[nsGroup], group)
+					metrics = append(metrics, prepareMetric(nuns, metricName, data))
+				}
+			}
 		} else if len(ns) == 5 && ns[nsCategory].Value == "state" { // globally aggregated process states
 			metricName := ns[nsStateName].Value
 
@@ -367,89 +860,599 @@ func (procPlg *procPlugin) CollectMetrics(metricTypes []plugin.Metric) ([]plugin
 					metrics = append(metrics, prepareMetric(nuns, metricName, val))
 				}
 			}
+		} else if len(ns) == 5 && ns[nsCategory].Value == "collector" { // plugin self-telemetry
+			collectorMts = append(collectorMts, ns)
 		} else {
 			return nil, fmt.Errorf("Bad namespace: %s", strings.Join(ns.Strings(), "/"))
 		}
 	}
+	if len(pidMts) > 0 {
+		metrics = appendPidMetrics(metrics, pidMts, stats, snap, tagger)
+	}
+
+	if len(collectorMts) > 0 {
+		collectorStats := map[string]interface{}{
+			"reads_denied":             readsDenied,
+			"pids_scanned":             scan.Scanned,
+			"pids_skipped_vanished":    scan.Vanished,
+			"pids_skipped_permission":  scan.Denied,
+			"pids_skipped_parse_error": scan.ParseErrors,
+			"pids_excluded":            scan.Excluded,
+			"metrics_emitted":          uint64(len(metrics)),
+		}
+		if rss, err := getSelfRSS(); err == nil {
+			collectorStats["rss_bytes"] = rss
+		} else {
+			log.WithFields(log.Fields{
+				"file":  selfStatusPath,
+				"error": err,
+			}).Error("Cannot get resident set size of the plugin")
+		}
+		collectorStats["collection_duration"] = time.Since(start).Seconds()
+
+		for _, ns := range collectorMts {
+			metricName := ns[nsCollector].Value
+			if val, ok := collectorStats[metricName]; ok {
+				nuns := append([]plugin.NamespaceElement{}, ns...)
+				metrics = append(metrics, prepareMetric(nuns, metricName, val))
+			}
+		}
+	}
 
 	return metrics, nil
 }
 
-func setProcMetrics(instance Proc) (map[string]interface{}, error) {
-	var procMetrics = make(map[string]interface{})
+// getStatsOptions returns options of reading procfs based on config and requested metrics
+func getStatsOptions(metricTypes []plugin.Metric) (statsOptions, error) {
+	cfg := metricTypes[0].Config
+	// files needed by metrics of groupings which are not enabled are not read
+	metricTypes, err := enabledMetricTypes(metricTypes)
+	if err != nil {
+		return statsOptions{}, err
+	}
+	nameSource := nameArgv0
+	if source, err := cfg.GetString("name_source"); err == nil {
+		if _, ok := nameSourceFiles[source]; !ok {
+			return statsOptions{}, fmt.Errorf("Invalid name_source: %s, expected one of %s, %s, %s or %s", source, nameComm, nameExe, nameArgv0, nameInterpreter)
+		}
+		nameSource = source
+	}
+	groupRules := []groupRule{}
+	if rules, err := cfg.GetString("group_rules"); err == nil {
+		groupRules, err = parseGroupRules(rules)
+		if err != nil {
+			return statsOptions{}, fmt.Errorf("Invalid group_rules: %v", err)
+		}
+	}
+	tagNames, err := getTagNames(cfg, metricTypes)
+	if err != nil {
+		return statsOptions{}, err
+	}
+	nameFiles := []string{nameSourceFiles[nameSource]}
+	// group rules are matched against command line
+	if len(groupRules) > 0 {
+		nameFiles = append(nameFiles, procCmd)
+	}
+	filter, err := getProcFilter(cfg)
+	if err != nil {
+		return statsOptions{}, err
+	}
+	opts := statsOptions{
+		sources:    requiredSources(metricTypes, nameFiles...),
+		nameSource: nameSource,
+		groupRules: groupRules,
+	}
+	// excluded processes are only counted in states so filter is needed only for metrics of processes and their groups
+	for _, metricType := range metricTypes {
+		if ns := metricType.Namespace; len(ns) > nsCategory && (ns[nsCategory].Value == "process" || isGrouping(ns[nsCategory].Value)) {
+			opts.filter = filter
+		}
+	}
+	for _, source := range opts.filter.files(nameFiles...) {
+		if source != "" {
+			opts.sources[source] = true
+		}
+	}
+	for _, name := range tagNames {
+		if source := tagFiles[name]; source != "" {
+			opts.sources[source] = true
+		}
+	}
+	// reading smaps is expensive so it is disabled when not configured
+	if smaps, err := cfg.GetBool("collect_smaps"); err != nil || !smaps {
+		delete(opts.sources, procSmaps)
+	}
+	// reading threads is expensive so it is disabled when not configured
+	if threads, err := cfg.GetBool("collect_threads"); err != nil || !threads {
+		delete(opts.sources, procTask)
+	}
+	// procfs is read sequentially when not configured
+	if workers, err := cfg.GetInt("scan_workers"); err == nil {
+		opts.workers = int(workers)
+	}
+	return opts, nil
+}
+
+// getProcFilter returns filter of processes built from config, nil is returned when no process is excluded
+func getProcFilter(cfg plugin.Config) (*procFilter, error) {
+	var includeNames, excludeNames, includeCmdline, excludeUsers string
+	var excludeKernelThreads bool
+	if val, err := cfg.GetString("include_names"); err == nil {
+		includeNames = val
+	}
+	if val, err := cfg.GetString("exclude_names"); err == nil {
+		excludeNames = val
+	}
+	if val, err := cfg.GetString("include_cmdline_regex"); err == nil {
+		includeCmdline = val
+	}
+	if val, err := cfg.GetString("exclude_users"); err == nil {
+		excludeUsers = val
+	}
+	if val, err := cfg.GetBool("exclude_kernel_threads"); err == nil {
+		excludeKernelThreads = val
+	}
 
-	if len(instance.Stat) < 29 {
-		return nil, fmt.Errorf("Process instance stat data not available or broken")
+	excludeUids := map[int]bool{}
+	if users := splitList(excludeUsers); len(users) > 0 {
+		// users given by UIDs are still excluded when passwd cannot be read
+		excludeUids = resolveUids(users, getPasswd(cfg))
 	}
 
-	vm, err := strconv.ParseUint(string(instance.Stat[22]), 10, 64)
+	filter, err := newProcFilter(includeNames, excludeNames, includeCmdline, excludeUids, excludeKernelThreads)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Invalid process filter: %v", err)
 	}
-	procMetrics["ps_vm"] = vm
+	return filter, nil
+}
 
-	rss, err := strconv.ParseUint(string(instance.Stat[23]), 10, 64)
+// getGroupKeys returns functions assigning processes to groups for requested categories of grouping,
+// stats are used to build groups depending on other processes, e.g. process tree
+func getGroupKeys(metricTypes []plugin.Metric, stats map[string]map[int]Proc) (map[string]groupKey, error) {
+	cfg := metricTypes[0].Config
+	metricTypes, err := enabledMetricTypes(metricTypes)
 	if err != nil {
 		return nil, err
 	}
-	procMetrics["ps_rss"] = rss
-
-	procMetrics["ps_data"] = instance.VmData
-	procMetrics["ps_code"] = instance.VmCode
-	procMetrics["ps_cmdline"] = instance.CmdLine
+	groupKeys := map[string]groupKey{}
+	for _, metricType := range metricTypes {
+		ns := metricType.Namespace
+		if len(ns) <= nsCategory || groupKeys[ns[nsCategory].Value] != nil {
+			continue
+		}
+		switch ns[nsCategory].Value {
+		case groupUser:
+			uidIndex, err := getUIDIndex(cfg)
+			if err != nil {
+				return nil, err
+			}
+			groupKeys[groupUser] = userKey(getPasswd(cfg), uidIndex)
+		case groupCgroup:
+			depth := defaultCgroupDepth
+			if val, err := cfg.GetInt("cgroup_depth"); err == nil {
+				depth = int(val)
+			}
+			groupKeys[groupCgroup] = cgroupKey(depth)
+		case groupUnit:
+			groupKeys[groupUnit] = unitKey()
+		case groupContainer:
+			groupKeys[groupContainer] = containerKey()
+		case groupAncestor:
+			groupKeys[groupAncestor] = ancestorKey(stats)
+		}
+	}
+	return groupKeys, nil
+}
 
-	stack1, err := strconv.ParseUint(string(instance.Stat[27]), 10, 64)
+// getUIDIndex returns index of UID of processes selected by user_uid config in Uid line of status
+func getUIDIndex(cfg plugin.Config) (int, error) {
+	uid, err := cfg.GetString("user_uid")
 	if err != nil {
-		return nil, err
+		return uidEffective, nil
 	}
-	stack2, err := strconv.ParseUint(string(instance.Stat[28]), 10, 64)
+	switch uid {
+	case userUIDEffective:
+		return uidEffective, nil
+	case userUIDReal:
+		return uidReal, nil
+	}
+	return 0, fmt.Errorf("Invalid user_uid: %s, expected %s or %s", uid, userUIDReal, userUIDEffective)
+}
+
+// getTagNames returns names of tags of metrics of process instances selected by tags config,
+// no tags are returned when such metrics are not requested
+func getTagNames(cfg plugin.Config, metricTypes []plugin.Metric) ([]string, error) {
+	tags := ""
+	if val, err := cfg.GetString("tags"); err == nil {
+		tags = val
+	}
+	names, err := parseTags(tags)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Invalid tags: %v", err)
+	}
+	for _, metricType := range metricTypes {
+		ns := metricType.Namespace
+		if len(ns) == 7 && ns[nsCategory].Value == "process" && ns[nsPid].Value != "all" {
+			return names, nil
+		}
 	}
+	return nil, nil
+}
 
-	// to avoid overload
-	if stack1 > stack2 {
-		procMetrics["ps_stacksize"] = stack1 - stack2
-	} else {
-		procMetrics["ps_stacksize"] = stack2 - stack1
+// getTagger returns tagger of metrics of process instances, nil is returned when no tags are attached
+func (procPlg *procPlugin) getTagger(metricTypes []plugin.Metric, procPath string) (*procTagger, error) {
+	cfg := metricTypes[0].Config
+	names, err := getTagNames(cfg, metricTypes)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	tagger := &procTagger{names: names, host: procPlg.host, clockTicks: procPlg.clockTicks}
+	if val, err := cfg.GetInt("cmdline_tag_max_length"); err == nil {
+		tagger.cmdlineMaxLength = int(val)
 	}
+	for _, name := range names {
+		switch name {
+		case tagUser:
+			uidIndex, err := getUIDIndex(cfg)
+			if err != nil {
+				return nil, err
+			}
+			tagger.user = userKey(getPasswd(cfg), uidIndex)
+		case tagStartTime:
+			fileName := filepath.Join(procPath, hostStat)
+			if tagger.bootTime, err = readBootTime(fileName); err != nil {
+				// start time tag is not set
+				log.WithFields(log.Fields{
+					"file":  fileName,
+					"error": err,
+				}).Warn("Cannot get boot time")
+			}
+		}
+	}
+	return tagger, nil
+}
 
-	utime, err := strconv.ParseUint(string(instance.Stat[13]), 10, 64)
+// enabledMetricTypes returns requested metric types without metrics of groupings not enabled by group_by config,
+// they are not collected
+func enabledMetricTypes(metricTypes []plugin.Metric) ([]plugin.Metric, error) {
+	enabled, err := getGroupBy(metricTypes[0].Config)
 	if err != nil {
 		return nil, err
 	}
-	procMetrics["ps_cputime_user"] = utime
+	enabledTypes := []plugin.Metric{}
+	for _, metricType := range metricTypes {
+		if ns := metricType.Namespace; len(ns) > nsCategory && isGrouping(ns[nsCategory].Value) && !enabled[ns[nsCategory].Value] {
+			continue
+		}
+		enabledTypes = append(enabledTypes, metricType)
+	}
+	return enabledTypes, nil
+}
 
-	stime, err := strconv.ParseUint(string(instance.Stat[14]), 10, 64)
+// getGroupBy returns categories of groupings enabled by group_by config
+func getGroupBy(cfg plugin.Config) (map[string]bool, error) {
+	groupBy := defaultGroupBy
+	if val, err := cfg.GetString("group_by"); err == nil {
+		groupBy = val
+	}
+	enabled, err := parseGroupBy(groupBy)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Invalid group_by: %v", err)
 	}
-	procMetrics["ps_cputime_system"] = stime
+	return enabled, nil
+}
 
-	minflt, err := strconv.ParseUint(string(instance.Stat[9]), 10, 64)
+// getPasswd returns user names by UID from passwd under root_path, empty map is returned when it cannot be read
+func getPasswd(cfg plugin.Config) map[int]string {
+	rootPath := "/"
+	if val, err := cfg.GetString("root_path"); err == nil {
+		rootPath = val
+	}
+	fileName := filepath.Join(rootPath, passwdPath)
+	passwd, err := readPasswd(fileName)
 	if err != nil {
-		return nil, err
+		log.WithFields(log.Fields{
+			"file":  fileName,
+			"error": err,
+		}).Warn("Cannot read user accounts")
+		return map[int]string{}
+	}
+	return passwd
+}
+
+// requiredSources returns files of /proc/<pid> which have to be read to calculate requested metrics,
+// nameFiles are files needed to name processes
+func requiredSources(metricTypes []plugin.Metric, nameFiles ...string) map[string]bool {
+	sources := map[string]bool{}
+	for _, metricType := range metricTypes {
+		ns := metricType.Namespace
+		if len(ns) <= nsCategory {
+			continue
+		}
+		// process names are needed only for process metrics
+		if ns[nsCategory].Value == "process" {
+			for _, source := range nameFiles {
+				if source != "" {
+					sources[source] = true
+				}
+			}
+		}
+		for _, source := range groupings[ns[nsCategory].Value].sources {
+			sources[source] = true
+		}
+		for _, source := range metricNames[ns[len(ns)-1].Value].sources {
+			sources[source] = true
+		}
 	}
-	procMetrics["ps_pagefaults_min"] = minflt
+	return sources
+}
 
-	majflt, err := strconv.ParseUint(string(instance.Stat[11]), 10, 64)
+// getSelfRSS returns resident set size of the plugin process in bytes
+func getSelfRSS() (uint64, error) {
+	status, err := read2Map(selfStatusPath)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	rss, ok := status["VmRSS"]
+	if !ok {
+		return 0, fmt.Errorf("VmRSS not found in %s", selfStatusPath)
+	}
+	return rss * 1024, nil
+}
+
+func setProcMetrics(instance Proc) map[string]interface{} {
+	var procMetrics = make(map[string]interface{})
+
+	procMetrics["ps_vm"] = instance.Stat.VSize
+	procMetrics["ps_rss"] = instance.Stat.Rss
+	procMetrics["ps_rss_bytes"] = instance.Stat.Rss * pageSize
+	// metrics based on files which were not read or could not be read are not reported
+	if instance.Status != nil {
+		procMetrics["ps_data"] = instance.VmData
+		procMetrics["ps_code"] = instance.VmCode
+	}
+	if _, unreadable := instance.Unreadable[procCmd]; !unreadable {
+		procMetrics["ps_cmdline"] = instance.CmdLine
 	}
-	procMetrics["ps_pagefaults_maj"] = majflt
 
-	procMetrics["ps_disk_octets_rchar"] = instance.Io["rchar"]
-	procMetrics["ps_disk_octets_wchar"] = instance.Io["wchar"]
-	procMetrics["ps_disk_ops_syscr"] = instance.Io["syscr"]
-	procMetrics["ps_disk_ops_syscw"] = instance.Io["syscw"]
+	if container := cgroupContainer(instance.Cgroup); container != "" {
+		procMetrics["ps_container_id"] = container
+	}
+	if pod := cgroupPod(instance.Cgroup); pod != "" {
+		procMetrics["ps_pod_uid"] = pod
+	}
+
+	procMetrics["ps_threads"] = uint64(instance.Stat.NumThreads)
+	procMetrics["ps_ppid"] = uint64(instance.Stat.Ppid)
+
+	if val, ok := instance.Status["voluntary_ctxt_switches"]; ok {
+		procMetrics["ps_ctxt_switches_voluntary"] = val
+	}
+	if val, ok := instance.Status["nonvoluntary_ctxt_switches"]; ok {
+		procMetrics["ps_ctxt_switches_nonvoluntary"] = val
+	}
+
+	// file descriptors of processes owned by other users are not accessible without privileges
+	if instance.FdCount != nil {
+		procMetrics["ps_fd_count"] = *instance.FdCount
+	}
+	if fdLimit, ok := instance.Limits[limitOpenFiles]; ok {
+		procMetrics["ps_fd_limit_soft"] = fdLimit.Soft
+		procMetrics["ps_fd_limit_hard"] = fdLimit.Hard
+		if instance.FdCount != nil && fdLimit.Soft > 0 {
+			procMetrics["ps_fd_utilization"] = float64(*instance.FdCount) / float64(fdLimit.Soft)
+		}
+	}
+
+	for _, limit := range resourceLimits {
+		if val, ok := instance.Limits[limit.name]; ok {
+			procMetrics[limit.metric+"_soft"] = val.Soft
+			procMetrics[limit.metric+"_hard"] = val.Hard
+		}
+	}
+
+	// smaps are collected only when enabled in configuration
+	if instance.Smaps != nil {
+		procMetrics["ps_pss"] = instance.Smaps["Pss"] * 1024
+		procMetrics["ps_uss"] = (instance.Smaps["Private_Clean"] + instance.Smaps["Private_Dirty"] + instance.Smaps["Private_Hugetlb"]) * 1024
+		procMetrics["ps_swap_pss"] = instance.Smaps["SwapPss"] * 1024
+		procMetrics["ps_shared_clean"] = instance.Smaps["Shared_Clean"] * 1024
+		procMetrics["ps_shared_dirty"] = instance.Smaps["Shared_Dirty"] * 1024
+	}
+
+	// fields missing in status (e.g. for kernel threads or zombies) are not reported
+	for field, metricName := range statusMemory {
+		if val, ok := instance.Status[field]; ok {
+			procMetrics[metricName] = val * 1024
+		}
+	}
+
+	// to avoid overload
+	if instance.Stat.StartStack > instance.Stat.KstkEsp {
+		procMetrics["ps_stacksize"] = instance.Stat.StartStack - instance.Stat.KstkEsp
+	} else {
+		procMetrics["ps_stacksize"] = instance.Stat.KstkEsp - instance.Stat.StartStack
+	}
+
+	procMetrics["ps_cputime_user"] = instance.Stat.Utime
+	procMetrics["ps_cputime_system"] = instance.Stat.Stime
+	procMetrics["ps_pagefaults_min"] = instance.Stat.MinFlt
+	procMetrics["ps_pagefaults_maj"] = instance.Stat.MajFlt
+
+	// I/O statistics of processes owned by other users are not accessible without privileges
+	if instance.Io != nil {
+		procMetrics["ps_disk_octets_rchar"] = instance.Io["rchar"]
+		procMetrics["ps_disk_octets_wchar"] = instance.Io["wchar"]
+		procMetrics["ps_disk_ops_syscr"] = instance.Io["syscr"]
+		procMetrics["ps_disk_ops_syscw"] = instance.Io["syscw"]
+		procMetrics["ps_disk_octets_read_bytes"] = instance.Io["read_bytes"]
+		procMetrics["ps_disk_octets_write_bytes"] = instance.Io["write_bytes"]
+		procMetrics["ps_disk_octets_cancelled_write_bytes"] = instance.Io["cancelled_write_bytes"]
+	}
 
-	return procMetrics, nil
+	return procMetrics
+}
+
+func setThreadMetrics(thread Thread) map[string]interface{} {
+	return map[string]interface{}{
+		"thread_name":                       thread.Stat.Comm,
+		"thread_state":                      thread.Stat.State,
+		"thread_cputime_user":               thread.Stat.Utime,
+		"thread_cputime_system":             thread.Stat.Stime,
+		"thread_ctxt_switches_voluntary":    thread.Status["voluntary_ctxt_switches"],
+		"thread_ctxt_switches_nonvoluntary": thread.Status["nonvoluntary_ctxt_switches"],
+	}
+}
+
+// snapshot holds metrics of all process instances calculated once per collection
+type snapshot struct {
+	// metrics holds metrics of process instances by PID
+	metrics map[int]map[string]interface{}
+	// aggregated holds metrics aggregated over instances by process name
+	aggregated map[string]map[string]interface{}
+	// groups holds metrics aggregated over instances by category of grouping and group
+	groups map[string]map[string]map[string]interface{}
+}
+
+// newSnapshot calculates metrics of each process instance including ones which depend on clock tick rate
+// and rates since previous collection (live PIDs are used to expire its state); metrics aggregated by process name
+// are calculated when withAggregates is set and metrics aggregated by group are calculated for categories of groupKeys
+func (procPlg *procPlugin) newSnapshot(stats map[string]map[int]Proc, live map[int]bool, cpuCount int, withAggregates bool, groupKeys map[string]groupKey, now time.Time) snapshot {
+	snap := snapshot{
+		metrics:    map[int]map[string]interface{}{},
+		aggregated: map[string]map[string]interface{}{},
+		groups:     map[string]map[string]map[string]interface{}{},
+	}
+	for _, process := range stats {
+		for pid, instance := range process {
+			snap.metrics[pid] = setProcMetrics(instance)
+		}
+	}
+
+	// calculate rates of cumulative metrics since previous collection
+	rates := procPlg.rates.update(stats, live, snap.metrics, now)
+	for processName, process := range stats {
+		aggregated := map[string]interface{}{}
+		for pid := range process {
+			procMetrics := snap.metrics[pid]
+			setCPUSeconds(procMetrics, float64(procPlg.clockTicks))
+			setCPUPercent(procMetrics, rates[pid], float64(procPlg.clockTicks), cpuCount)
+			setRates(procMetrics, rates[pid])
+
+			if withAggregates {
+				aggregateMetrics(aggregated, procMetrics)
+			}
+		}
+		snap.aggregated[processName] = aggregated
+	}
+	for category, key := range groupKeys {
+		snap.groups[category] = aggregateGroups(stats, snap.metrics, key)
+	}
+	return snap
+}
+
+// aggregateMetrics adds numeric metrics of process instance procMetrics to aggregated metrics
+func aggregateMetrics(aggregated, procMetrics map[string]interface{}) {
+	for metricName, val := range procMetrics {
+		if metricNames[metricName].aggregation == aggrNone {
+			continue
+		}
+		if aggr := aggregate(metricNames[metricName].aggregation, aggregated[metricName], val); aggr != nil {
+			aggregated[metricName] = aggr
+		}
+	}
+}
+
+// aggregate combines numeric val with aggregated value acc using given aggregation function,
+// nil is returned for non-numeric values
+func aggregate(aggregation string, acc, val interface{}) interface{} {
+	switch v := val.(type) {
+	case uint64:
+		a, ok := acc.(uint64)
+		if !ok {
+			return v
+		}
+		switch aggregation {
+		case aggrMin:
+			if v < a {
+				return v
+			}
+			return a
+		case aggrMax:
+			if v > a {
+				return v
+			}
+			return a
+		}
+		return a + v
+	case float64:
+		a, ok := acc.(float64)
+		if !ok {
+			return v
+		}
+		switch aggregation {
+		case aggrMin:
+			return math.Min(a, v)
+		case aggrMax:
+			return math.Max(a, v)
+		}
+		return a + v
+	}
+	return nil
 }
 
 func fillNsElement(element *plugin.NamespaceElement, value string) plugin.NamespaceElement {
 	return plugin.NamespaceElement{Value: value, Description: element.Description, Name: element.Name}
 }
 
+// appendPidMetrics appends requested metrics of process instances from the snapshot to metrics,
+// PID and tags of each instance are determined once for all requested metrics
+func appendPidMetrics(metrics []plugin.Metric, pidMts []plugin.Namespace, stats map[string]map[int]Proc, snap snapshot, tagger *procTagger) []plugin.Metric {
+	labels := make([]label, len(pidMts))
+	for i, ns := range pidMts {
+		labels[i] = metricNames[ns[nsPidMetric].Value]
+	}
+	timestamp := time.Now()
+	for processName, process := range stats {
+		for processPid, instance := range process {
+			pid := strconv.Itoa(processPid)
+			var tags map[string]string
+			tagged := false
+			for i, ns := range pidMts {
+				reqProcName := ns[nsProcName].Value
+				reqProcPID := ns[nsPid].Value
+				if (processName != reqProcName && reqProcName != "*") || (pid != reqProcPID && reqProcPID != "*") {
+					continue
+				}
+				metricName := ns[nsPidMetric].Value
+				// metrics derived from rates are not available in the first collection
+				data, ok := snap.metrics[processPid][metricName]
+				if !ok && labels[i].category == "pid" {
+					continue
+				}
+				nuns := append([]plugin.NamespaceElement{}, ns...)
+				nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
+				nuns[nsPid] = fillNsElement(&nuns[nsPid], pid)
+
+				// tags are the same for all metrics of the process
+				if !tagged {
+					tags = tagger.tags(instance)
+					tagged = true
+				}
+				metrics = append(metrics, plugin.Metric{
+					Namespace:   nuns,
+					Data:        data,
+					Timestamp:   timestamp,
+					Unit:        labels[i].unit,
+					Description: labels[i].description,
+					Tags:        tags,
+				})
+			}
+		}
+	}
+	return metrics
+}
+
 func prepareMetric(ns []plugin.NamespaceElement, metricName string, data interface{}) plugin.Metric {
 	return plugin.Metric{
 		Namespace:   ns,
@@ -460,14 +1463,30 @@ func prepareMetric(ns []plugin.NamespaceElement, metricName string, data interfa
 	}
 }
 
-// procPlugin holds host name and reference to metricCollector which has method of GetStats()
+// procPlugin holds host name, reference to metricCollector which has method of GetStats()
+// and state of previous collection needed to calculate rates
 type procPlugin struct {
-	host string
-	mc   metricCollector
+	host       string
+	mc         metricCollector
+	rates      *rateTracker
+	clockTicks uint64
+}
+
+// resourceLimit describes resource limit of the process exposed as metrics
+type resourceLimit struct {
+	// name of the limit in /proc/<pid>/limits
+	name        string
+	metric      string
+	description string
+	unit        string
 }
 
 type label struct {
 	description string
 	unit        string
 	category    string
+	// aggregation is function used to aggregate values of process instances, sum by default
+	aggregation string
+	// sources are files of /proc/<pid> the metric is based on, stat is always read
+	sources []string
 }
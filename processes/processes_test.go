@@ -24,9 +24,14 @@ package processes
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
 	. "github.com/smartystreets/goconvey/convey"
@@ -54,13 +59,17 @@ type mcMock struct {
 	mock.Mock
 }
 
-func (mc *mcMock) GetStats(procPath string) (map[string]map[int]Proc, error) {
+func (mc *mcMock) GetStats(procPath string, opts statsOptions) (map[string]map[int]Proc, scanStats, error) {
 	args := mc.Called()
 	var r0 map[string]map[int]Proc
 	if args.Get(0) != nil {
 		r0 = args.Get(0).(map[string]map[int]Proc)
 	}
-	return r0, args.Error(1)
+	// summary of the scan is optional, it is returned between statistics and error
+	if len(args) > 2 {
+		return r0, args.Get(1).(scanStats), args.Error(2)
+	}
+	return r0, scanStats{}, args.Error(1)
 }
 
 func TestGetConfigPolicy(t *testing.T) {
@@ -94,13 +103,40 @@ func TestGetMetricTypes(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(results, ShouldNotBeEmpty)
 
-		// plugin returns total of 38 metrics available, see the README.md
-		So(len(results), ShouldEqual, 38)
+		// plugin returns total of 425 metrics available with default groupings, see the README.md
+		So(len(results), ShouldEqual, 425)
 
 		for _, res := range results {
 			So(res.Description, ShouldNotBeBlank)
+			So(res.Namespace[nsCategory].Value, ShouldNotBeIn, "unit", "ancestor")
 		}
 	})
+
+	Convey("get metric types of groupings enabled in config", t, func() {
+		procPlugin := New()
+
+		Convey("all groupings are enabled", func() {
+			results, err := procPlugin.GetMetricTypes(plugin.Config{"group_by": "user,cgroup,systemd_unit,container,ancestor"})
+
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 583)
+		})
+
+		Convey("no grouping is enabled", func() {
+			results, err := procPlugin.GetMetricTypes(plugin.Config{"group_by": ""})
+
+			So(err, ShouldBeNil)
+			for _, res := range results {
+				So(isGrouping(res.Namespace[nsCategory].Value), ShouldBeFalse)
+			}
+		})
+
+		Convey("grouping is invalid", func() {
+			_, err := procPlugin.GetMetricTypes(plugin.Config{"group_by": "session"})
+
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
 
 func TestCollectMetrics(t *testing.T) {
@@ -360,6 +396,687 @@ func TestCollectMetrics(t *testing.T) {
 
 		})
 
+		Convey("when CPU utilization is collected in consecutive collections", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			busyProc := makeMockProc("fake", mockProcPid2)
+			busyProc.Stat.Utime += 100
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"fake": map[int]Proc{mockProcPid2: mockProc2},
+			}, nil).Once()
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"fake": map[int]Proc{mockProcPid2: busyProc},
+			}, nil).Once()
+
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddDynamicElement("process_pid", "identifier of the process").
+						AddStaticElement("ps_cpu_percent_user"),
+					Config: cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "fake", "all", "ps_cpu_percent_total"),
+					Config:    cfg,
+				},
+			}
+
+			results, err := procPlugin.CollectMetrics(mts)
+			So(err, ShouldBeNil)
+			// no previous sample in the first collection
+			So(results, ShouldBeEmpty)
+
+			results, err = procPlugin.CollectMetrics(mts)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+			for _, r := range results {
+				So(r.Data, ShouldBeGreaterThan, 0)
+			}
+		})
+
+		Convey("when getStats() returns processes with threads", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			threadedProc := makeMockProc("fake", mockProcPid2)
+			threadedProc.Threads = map[int]Thread{}
+			for _, tid := range []int{mockProcPid2, mockProcPid2 + 1} {
+				thread := Thread{Tid: tid, Stat: threadedProc.Stat, Status: map[string]uint64{
+					"voluntary_ctxt_switches":    uint64(tid),
+					"nonvoluntary_ctxt_switches": 3,
+				}}
+				thread.Stat.Pid = tid
+				thread.Stat.Comm = "worker-" + strconv.Itoa(tid)
+				threadedProc.Threads[tid] = thread
+			}
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: mockProc},
+				"fake":           map[int]Proc{mockProcPid2: threadedProc},
+			}, nil)
+
+			Convey("all threads are returned for dynamic thread identifier", func() {
+				results, err := procPlugin.CollectMetrics([]plugin.Metric{
+					plugin.Metric{
+						Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+							AddDynamicElement("process_name", "name of the process").
+							AddDynamicElement("process_pid", "identifier of the process").
+							AddStaticElement("thread").
+							AddDynamicElement("thread_tid", "identifier of the thread").
+							AddStaticElement("thread_name"),
+						Config: cfg,
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(len(results), ShouldEqual, 2)
+				for _, r := range results {
+					So(r.Namespace[4].Value, ShouldEqual, "fake")
+					So(r.Data, ShouldEqual, "worker-"+r.Namespace[7].Value)
+				}
+			})
+
+			Convey("single thread is returned for specified thread identifier", func() {
+				results, err := procPlugin.CollectMetrics([]plugin.Metric{
+					plugin.Metric{
+						Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "fake",
+							strconv.Itoa(mockProcPid2), "thread", strconv.Itoa(mockProcPid2+1), "thread_ctxt_switches_voluntary"),
+						Config: cfg,
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(len(results), ShouldEqual, 1)
+				So(results[0].Data, ShouldEqual, mockProcPid2+1)
+			})
+
+			Convey("number of threads is returned per process", func() {
+				results, err := procPlugin.CollectMetrics([]plugin.Metric{
+					plugin.Metric{
+						Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "fake", "all", "ps_threads"),
+						Config:    cfg,
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(len(results), ShouldEqual, 1)
+				So(results[0].Data, ShouldEqual, threadedProc.Stat.NumThreads)
+			})
+		})
+
+		Convey("when getStats() returns processes with files which could not be read", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			deniedProc := makeMockProc("fake", mockProcPid2)
+			deniedProc.Io = nil
+			deniedProc.FdCount = nil
+			deniedProc.Unreadable = map[string]error{
+				procIO: os.ErrPermission,
+				procFd: os.ErrPermission,
+				// vanished process is not counted as denied read
+				procLimits: os.ErrNotExist,
+			}
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: mockProc},
+				"fake":           map[int]Proc{mockProcPid2: deniedProc},
+			}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "collector", "reads_denied"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "sleeping"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddDynamicElement("process_pid", "identifier of the process").
+						AddStaticElement("ps_disk_octets_rchar"),
+					Config: cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddDynamicElement("process_pid", "identifier of the process").
+						AddStaticElement("ps_vm"),
+					Config: cfg,
+				},
+			})
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			So(matched["intel/procfs/processes/collector/reads_denied"], ShouldEqual, 2)
+			So(matched["intel/procfs/processes/state/sleeping"], ShouldEqual, 2)
+			// I/O metrics are omitted for process with unreadable io, other metrics are available
+			So(matched, ShouldContainKey, "intel/procfs/processes/process/NetworkManager/815/ps_disk_octets_rchar")
+			So(matched, ShouldNotContainKey, "intel/procfs/processes/process/fake/315/ps_disk_octets_rchar")
+			So(matched, ShouldContainKey, "intel/procfs/processes/process/fake/315/ps_vm")
+		})
+
+		Convey("when name source is invalid", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+			mc.On("GetStats").Return(map[string]map[int]Proc{}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "running"),
+					Config:    plugin.Config{"proc_path": "/proc", "name_source": "basename"},
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("when group rules are invalid", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+			mc.On("GetStats").Return(map[string]map[int]Proc{}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "running"),
+					Config:    plugin.Config{"proc_path": "/proc", "group_rules": "web=(nginx"},
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("when getStats() excludes processes by filter", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: mockProc},
+			}, scanStats{Scanned: 4, Excluded: 3, ExcludedStates: map[string]uint64{"S": 2, "?": 1}}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "sleeping"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "unknown"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "collector", "pids_excluded"),
+					Config:    cfg,
+				},
+			})
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			// excluded processes are counted in states
+			So(matched["intel/procfs/processes/state/sleeping"], ShouldEqual, 3)
+			So(matched["intel/procfs/processes/state/unknown"], ShouldEqual, 1)
+			So(matched["intel/procfs/processes/collector/pids_excluded"], ShouldEqual, 3)
+		})
+
+		Convey("when process filter is invalid", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+			mc.On("GetStats").Return(map[string]map[int]Proc{}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "running"),
+					Config:    plugin.Config{"proc_path": "/proc", "include_cmdline_regex": "(etl"},
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("when collector metrics are requested", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: mockProc},
+				"fake":           map[int]Proc{mockProcPid2: mockProc2, mockProcPid3: mockProc3},
+			}, scanStats{Scanned: 7, Vanished: 2, Denied: 1, ParseErrors: 1}, nil)
+
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddDynamicElement("process_pid", "identifier of the process").
+						AddStaticElement("ps_vm"),
+					Config: cfg,
+				},
+			}
+			for _, name := range []string{"collection_duration", "pids_scanned", "pids_skipped_vanished",
+				"pids_skipped_permission", "pids_skipped_parse_error", "metrics_emitted", "rss_bytes"} {
+				mts = append(mts, plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "collector", name),
+					Config:    cfg,
+				})
+			}
+			mts[0].Namespace[4].Value = "*"
+			mts[0].Namespace[5].Value = "*"
+
+			results, err := procPlugin.CollectMetrics(mts)
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			So(matched["intel/procfs/processes/collector/pids_scanned"], ShouldEqual, 7)
+			So(matched["intel/procfs/processes/collector/pids_skipped_vanished"], ShouldEqual, 2)
+			So(matched["intel/procfs/processes/collector/pids_skipped_permission"], ShouldEqual, 1)
+			So(matched["intel/procfs/processes/collector/pids_skipped_parse_error"], ShouldEqual, 1)
+			// collector metrics are not counted as emitted
+			So(matched["intel/procfs/processes/collector/metrics_emitted"], ShouldEqual, 3)
+			So(matched["intel/procfs/processes/collector/collection_duration"], ShouldBeGreaterThanOrEqualTo, 0)
+			So(matched["intel/procfs/processes/collector/rss_bytes"], ShouldBeGreaterThan, 0)
+		})
+
+		Convey("when getStats() returns processes in idle and unknown states", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			idleProc := makeMockProc("kworker", 4)
+			idleProc.State = "I"
+			unknownProc := makeMockProc("mystery", 5)
+			unknownProc.State = "?"
+
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"kworker": map[int]Proc{4: idleProc},
+				"mystery": map[int]Proc{5: unknownProc},
+			}, nil)
+
+			results, err := procPlugin.CollectMetrics([]plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "idle"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "unknown"),
+					Config:    cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "sleeping"),
+					Config:    cfg,
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 3)
+			for _, r := range results {
+				switch r.Namespace[4].Value {
+				case "idle", "unknown":
+					So(r.Data, ShouldEqual, 1)
+				case "sleeping":
+					So(r.Data, ShouldEqual, 0)
+				}
+			}
+		})
+
+		Convey("when tags of process instances are configured", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+			procPlugin.host = "node1"
+
+			instance := mockProc
+			instance.Cgroup = "/system.slice/NetworkManager.service"
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: instance},
+			}, nil)
+
+			tagsCfg := plugin.Config{"proc_path": "/proc", "tags": "hostname,pid,cgroup,cmdline", "cmdline_tag_max_length": int64(18)}
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddDynamicElement("process_pid", "identifier of the process").
+						AddStaticElement("ps_vm"),
+					Config: tagsCfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process").
+						AddDynamicElement("process_name", "name of the process").
+						AddStaticElement("all").
+						AddStaticElement("ps_vm"),
+					Config: tagsCfg,
+				},
+			}
+			mts[0].Namespace[4].Value = "*"
+			mts[0].Namespace[5].Value = "*"
+			mts[1].Namespace[4].Value = "*"
+
+			Convey("they are attached to metrics of process instances", func() {
+				results, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, 2)
+				for _, r := range results {
+					if r.Namespace[5].Value == "all" {
+						// aggregated metrics describe multiple processes
+						So(r.Tags, ShouldBeEmpty)
+						continue
+					}
+					So(r.Tags, ShouldResemble, map[string]string{
+						"hostname": "node1",
+						"pid":      strconv.Itoa(mockProcPid),
+						"cgroup":   "/system.slice/NetworkManager.service",
+						"cmdline":  "/usr/sbin/NetworkM",
+					})
+				}
+			})
+
+			Convey("unknown tag is reported", func() {
+				tagsCfg["tags"] = "hostname,container"
+				_, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Invalid tags")
+			})
+		})
+
+		Convey("when metrics aggregated by process subtree are requested", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			initProc, makeProc, ccProc, ldProc := makeMockProc("systemd", 1), makeMockProc("make", 200), makeMockProc("cc1", 201), makeMockProc("ld", 202)
+			initProc.Stat.Ppid, makeProc.Stat.Ppid, ccProc.Stat.Ppid, ldProc.Stat.Ppid = 0, 1, 200, 200
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"systemd": map[int]Proc{1: initProc},
+				"make":    map[int]Proc{200: makeProc},
+				"cc1":     map[int]Proc{201: ccProc},
+				"ld":      map[int]Proc{202: ldProc},
+			}, nil)
+
+			mts := []plugin.Metric{}
+			for _, name := range []string{"ps_count", "ps_threads"} {
+				mts = append(mts, plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "ancestor").
+						AddDynamicElement("ancestor", "name and PID of the top-level process of the subtree of the processes").
+						AddStaticElement(name),
+					Config: plugin.Config{"proc_path": "/proc", "group_by": "ancestor"},
+				})
+				mts[len(mts)-1].Namespace[4].Value = "*"
+			}
+			results, err := procPlugin.CollectMetrics(mts)
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			So(matched, ShouldResemble, map[string]interface{}{
+				"intel/procfs/processes/ancestor/systemd:1/ps_count":   uint64(1),
+				"intel/procfs/processes/ancestor/systemd:1/ps_threads": uint64(initProc.Stat.NumThreads),
+				"intel/procfs/processes/ancestor/make:200/ps_count":    uint64(3),
+				"intel/procfs/processes/ancestor/make:200/ps_threads":  uint64(makeProc.Stat.NumThreads + ccProc.Stat.NumThreads + ldProc.Stat.NumThreads),
+			})
+		})
+
+		Convey("when metrics aggregated by user are requested", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			rootPath, err := ioutil.TempDir("", "procfs_root")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(rootPath)
+			So(os.Mkdir(filepath.Join(rootPath, "etc"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(rootPath, passwdPath), []byte("root:x:0:0::/root:/bin/bash\nbuild:x:1000:1000::/home/build:/bin/bash\n"), 0644), ShouldBeNil)
+
+			rootProc, buildProc, setuidProc := mockProc, mockProc2, mockProc3
+			rootProc.Uids = []int{0, 0, 0, 0}
+			buildProc.Uids = []int{1000, 1000, 1000, 1000}
+			setuidProc.Uids = []int{1000, 0, 0, 0}
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: rootProc},
+				"fake":           map[int]Proc{mockProcPid2: buildProc, mockProcPid3: setuidProc},
+			}, nil)
+
+			mts := []plugin.Metric{}
+			for _, name := range []string{"ps_count", "ps_vm", "ps_threads"} {
+				mts = append(mts, plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "user").
+						AddDynamicElement("user_name", "name of the user owning the processes").
+						AddStaticElement(name),
+					Config: plugin.Config{"proc_path": "/proc", "root_path": rootPath, "user_uid": "effective"},
+				})
+				mts[len(mts)-1].Namespace[4].Value = "*"
+			}
+
+			Convey("processes are grouped by effective UID", func() {
+				results, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldBeNil)
+				matched := map[string]interface{}{}
+				for _, r := range results {
+					matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+				}
+				So(matched, ShouldHaveLength, 6)
+				So(matched["intel/procfs/processes/user/root/ps_count"], ShouldEqual, 2)
+				So(matched["intel/procfs/processes/user/root/ps_vm"], ShouldEqual, mockProc.Stat.VSize+mockProc3.Stat.VSize)
+				So(matched["intel/procfs/processes/user/build/ps_count"], ShouldEqual, 1)
+				So(matched["intel/procfs/processes/user/build/ps_threads"], ShouldEqual, mockProc2.Stat.NumThreads)
+			})
+
+			Convey("processes are grouped by real UID", func() {
+				for i := range mts {
+					mts[i].Config["user_uid"] = "real"
+				}
+				results, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldBeNil)
+				matched := map[string]interface{}{}
+				for _, r := range results {
+					matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+				}
+				So(matched["intel/procfs/processes/user/root/ps_count"], ShouldEqual, 1)
+				So(matched["intel/procfs/processes/user/build/ps_count"], ShouldEqual, 2)
+			})
+
+			Convey("UIDs are reported for users missing in passwd", func() {
+				for i := range mts {
+					mts[i].Config["root_path"] = filepath.Join(rootPath, "missing")
+				}
+				results, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldBeNil)
+				matched := map[string]interface{}{}
+				for _, r := range results {
+					matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+				}
+				So(matched["intel/procfs/processes/user/0/ps_count"], ShouldEqual, 2)
+				So(matched["intel/procfs/processes/user/1000/ps_count"], ShouldEqual, 1)
+			})
+
+			Convey("invalid UID selection is reported", func() {
+				mts[0].Config["user_uid"] = "saved"
+				_, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Invalid user_uid")
+			})
+		})
+
+		Convey("when metrics aggregated by cgroup are requested", func() {
+			mc := &mcMock{}
+			procPlugin.mc = mc
+
+			nginxProc, workerProc, sshdProc := mockProc, mockProc2, mockProc3
+			nginxProc.Cgroup = "/system.slice/nginx.service"
+			workerProc.Cgroup = "/system.slice/nginx.service/worker"
+			sshdProc.Cgroup = "/system.slice/sshd.service"
+			mc.On("GetStats").Return(map[string]map[int]Proc{
+				"NetworkManager": map[int]Proc{mockProcPid: nginxProc},
+				"fake":           map[int]Proc{mockProcPid2: workerProc, mockProcPid3: sshdProc},
+			}, nil)
+
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "cgroup").
+						AddDynamicElement("cgroup_path", "path of the cgroup of the processes").
+						AddStaticElement("ps_count"),
+					Config: plugin.Config{"proc_path": "/proc", "cgroup_depth": int64(2)},
+				},
+			}
+			mts[0].Namespace[4].Value = "*"
+			results, err := procPlugin.CollectMetrics(mts)
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			// nested cgroups are aggregated in their ancestor at configured depth
+			So(matched, ShouldHaveLength, 2)
+			So(matched["intel/procfs/processes/cgroup/system.slice.nginx.service/ps_count"], ShouldEqual, 2)
+			So(matched["intel/procfs/processes/cgroup/system.slice.sshd.service/ps_count"], ShouldEqual, 1)
+		})
+
+		Convey("when metrics aggregated by systemd unit are requested", func() {
+			procPath, err := createCgroupProcfs(map[int]string{
+				1:    "0::/init.scope\n",
+				2:    "0::/\n",
+				812:  "0::/system.slice/sshd.service\n",
+				4012: "0::/user.slice/user-1000.slice/session-2.scope\n",
+				4013: "0::/user.slice/user-1000.slice/session-2.scope\n",
+				// cgroup v1 hierarchies
+				920: "11:memory:/system.slice/nginx.service\n1:name=systemd:/system.slice/nginx.service\n",
+				921: "11:memory:/system.slice/nginx.service\n1:name=systemd:/system.slice/nginx.service/worker\n",
+				// user units are nested in unit of the user manager
+				5000: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/pipewire.service\n",
+			})
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(procPath)
+			procPlugin.mc = &procStatsCollector{}
+
+			cfg := plugin.Config{"proc_path": procPath, "group_by": "systemd_unit"}
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "unit").
+						AddDynamicElement("unit_name", "name of the systemd unit of the processes").
+						AddStaticElement("ps_count"),
+					Config: cfg,
+				},
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "cgroup").
+						AddDynamicElement("cgroup_path", "path of the cgroup of the processes").
+						AddStaticElement("ps_count"),
+					Config: cfg,
+				},
+			}
+			mts[0].Namespace[4].Value = "*"
+			mts[1].Namespace[4].Value = "*"
+
+			Convey("processes are grouped by unit derived from cgroup", func() {
+				results, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldBeNil)
+				matched := map[string]interface{}{}
+				for _, r := range results {
+					matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+				}
+				// kernel threads in the root cgroup have no unit, cgroup grouping is not enabled
+				So(matched, ShouldResemble, map[string]interface{}{
+					"intel/procfs/processes/unit/init.scope/ps_count":        uint64(1),
+					"intel/procfs/processes/unit/sshd.service/ps_count":      uint64(1),
+					"intel/procfs/processes/unit/session-2.scope/ps_count":   uint64(2),
+					"intel/procfs/processes/unit/nginx.service/ps_count":     uint64(2),
+					"intel/procfs/processes/unit/user@1000.service/ps_count": uint64(1),
+				})
+			})
+
+			Convey("invalid grouping is reported", func() {
+				cfg["group_by"] = "systemd_unit,session"
+				_, err := procPlugin.CollectMetrics(mts)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Invalid group_by")
+			})
+		})
+
+		Convey("when metrics aggregated by container are requested", func() {
+			procPath, err := createCgroupProcfs(map[int]string{
+				1:    "0::/init.scope\n",
+				3100: "0::/system.slice/docker-3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f.scope\n",
+				3101: "0::/system.slice/docker-3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f.scope\n",
+				3200: "12:memory:/kubepods/besteffort/pod0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0/f2e3d4c5b6a7f8e9d0c1b2a3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8a9b0c5e4f3\n",
+			})
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(procPath)
+			procPlugin.mc = &procStatsCollector{}
+
+			mts := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "container").
+						AddDynamicElement("container_id", "identifier of the container of the processes").
+						AddStaticElement("ps_count"),
+					Config: plugin.Config{"proc_path": procPath},
+				},
+			}
+			mts[0].Namespace[4].Value = "*"
+			results, err := procPlugin.CollectMetrics(mts)
+
+			So(err, ShouldBeNil)
+			matched := map[string]interface{}{}
+			for _, r := range results {
+				matched[strings.Join(r.Namespace.Strings(), "/")] = r.Data
+			}
+			// processes outside of containers are not reported
+			So(matched, ShouldResemble, map[string]interface{}{
+				"intel/procfs/processes/container/3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f/ps_count": uint64(2),
+				"intel/procfs/processes/container/f2e3d4c5b6a7f8e9d0c1b2a3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8a9b0c5e4f3/ps_count": uint64(1),
+			})
+		})
+
+		Convey("when tasks requesting different metrics share the plugin", func() {
+			createMockFiles()
+			defer deleteMockFiles()
+			procPlugin.mc = &procStatsCollector{}
+
+			rateTask := []plugin.Metric{
+				plugin.Metric{
+					Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_disk_octets_read_bytes_rate"),
+					Config:    plugin.Config{"proc_path": mockPath},
+				},
+			}
+			for _, cfg := range []plugin.Config{
+				plugin.Config{"proc_path": mockPath},
+				plugin.Config{"proc_path": mockPath, "exclude_names": "*"},
+			} {
+				// the other task reads neither io nor processes excluded by its filter
+				stateTask := []plugin.Metric{
+					plugin.Metric{
+						Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "sleeping"),
+						Config:    cfg,
+					},
+				}
+				_, err := procPlugin.CollectMetrics(rateTask)
+				So(err, ShouldBeNil)
+				_, err = procPlugin.CollectMetrics(stateTask)
+				So(err, ShouldBeNil)
+				results, err := procPlugin.CollectMetrics(rateTask)
+
+				// rates are calculated since the previous collection of the task
+				So(err, ShouldBeNil)
+				So(results, ShouldHaveLength, len(mockPid))
+			}
+		})
+
 		Convey("when getStats() returns statistics for multiple processes", func() {
 			mc := &mcMock{}
 			procPlugin.mc = mc
@@ -419,6 +1136,372 @@ func TestCollectMetrics(t *testing.T) {
 
 }
 
+func TestSetProcMetrics(t *testing.T) {
+
+	Convey("when process memory statistics are available", t, func() {
+		procMetrics := setProcMetrics(mockProc)
+
+		So(procMetrics["ps_rss_bytes"], ShouldEqual, mockProc.Stat.Rss*uint64(os.Getpagesize()))
+		So(procMetrics["ps_vm_peak"], ShouldEqual, 452000*1024)
+		So(procMetrics["ps_vm_hwm"], ShouldEqual, 9000*1024)
+		So(procMetrics["ps_vm_swap"], ShouldEqual, 120*1024)
+		So(procMetrics["ps_vm_pte"], ShouldEqual, 96*1024)
+		So(procMetrics["ps_rss_anon"], ShouldEqual, 4480*1024)
+		So(procMetrics["ps_rss_file"], ShouldEqual, 4100*1024)
+		So(procMetrics["ps_rss_shmem"], ShouldEqual, 0)
+	})
+
+	Convey("when process memory mappings statistics are available", t, func() {
+		proc := makeMockProc("fake", 1000)
+		proc.Smaps = map[string]uint64{
+			"Rss":           7844,
+			"Pss":           1660,
+			"Shared_Clean":  6160,
+			"Shared_Dirty":  0,
+			"Private_Clean": 268,
+			"Private_Dirty": 1416,
+			"SwapPss":       12,
+		}
+		procMetrics := setProcMetrics(proc)
+
+		So(procMetrics["ps_pss"], ShouldEqual, 1660*1024)
+		So(procMetrics["ps_uss"], ShouldEqual, (268+1416)*1024)
+		So(procMetrics["ps_swap_pss"], ShouldEqual, 12*1024)
+		So(procMetrics["ps_shared_clean"], ShouldEqual, 6160*1024)
+		So(procMetrics["ps_shared_dirty"], ShouldEqual, 0)
+	})
+
+	Convey("when I/O statistics are available", t, func() {
+		procMetrics := setProcMetrics(mockProc)
+
+		for _, metricName := range []string{"ps_disk_octets_read_bytes", "ps_disk_octets_write_bytes", "ps_disk_octets_cancelled_write_bytes"} {
+			So(mockProc.validateValue(metricName, procMetrics[metricName].(uint64)), ShouldBeTrue)
+		}
+	})
+
+	Convey("when context switches statistics are available", t, func() {
+		procMetrics := setProcMetrics(mockProc)
+
+		So(procMetrics["ps_ctxt_switches_voluntary"], ShouldEqual, 7251)
+		So(procMetrics["ps_ctxt_switches_nonvoluntary"], ShouldEqual, 38)
+	})
+
+	Convey("when file descriptors statistics are available", t, func() {
+		procMetrics := setProcMetrics(mockProc)
+
+		So(procMetrics["ps_fd_count"], ShouldEqual, 256)
+		So(procMetrics["ps_fd_limit_soft"], ShouldEqual, 1024)
+		So(procMetrics["ps_fd_limit_hard"], ShouldEqual, 4096)
+		So(procMetrics["ps_fd_utilization"], ShouldEqual, 0.25)
+	})
+
+	Convey("when resource limits are available", t, func() {
+		procMetrics := setProcMetrics(mockProc)
+
+		So(procMetrics["ps_limit_processes_soft"], ShouldEqual, 63432)
+		So(procMetrics["ps_limit_processes_hard"], ShouldEqual, 63432)
+		So(procMetrics["ps_limit_stack_size_soft"], ShouldEqual, 8388608)
+		So(procMetrics["ps_limit_stack_size_hard"], ShouldEqual, uint64(math.MaxUint64))
+		// limits missing in /proc/<pid>/limits are not reported
+		So(procMetrics, ShouldNotContainKey, "ps_limit_cpu_time_soft")
+	})
+
+	Convey("when file descriptors of process are not accessible", t, func() {
+		proc := makeMockProc("fake", 1000)
+		proc.FdCount = nil
+		proc.Unreadable = map[string]error{procFd: os.ErrPermission}
+		procMetrics := setProcMetrics(proc)
+
+		So(procMetrics, ShouldNotContainKey, "ps_fd_count")
+		So(procMetrics, ShouldNotContainKey, "ps_fd_utilization")
+		So(procMetrics["ps_fd_limit_soft"], ShouldEqual, 1024)
+	})
+
+	Convey("when process memory statistics are not available", t, func() {
+		zombie := makeMockProc("zombie", 1000)
+		zombie.Status = nil
+		procMetrics := setProcMetrics(zombie)
+
+		So(procMetrics, ShouldContainKey, "ps_rss_bytes")
+		for _, metricName := range statusMemory {
+			So(procMetrics, ShouldNotContainKey, metricName)
+		}
+		So(procMetrics, ShouldNotContainKey, "ps_pss")
+	})
+
+	Convey("when process runs in a container of Kubernetes pod", t, func() {
+		proc := makeMockProc("java", 1000)
+		proc.Cgroup = "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c_4b5a_6978_8796_a5b4c3d2e1f0.slice/" +
+			"cri-containerd-3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f.scope"
+		procMetrics := setProcMetrics(proc)
+
+		So(procMetrics["ps_container_id"], ShouldEqual, "3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f")
+		So(procMetrics["ps_pod_uid"], ShouldEqual, "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	})
+
+	Convey("when process does not run in a container", t, func() {
+		proc := makeMockProc("sshd", 1000)
+		proc.Cgroup = "/system.slice/sshd.service"
+		procMetrics := setProcMetrics(proc)
+
+		So(procMetrics, ShouldNotContainKey, "ps_container_id")
+		So(procMetrics, ShouldNotContainKey, "ps_pod_uid")
+	})
+}
+
+func BenchmarkCollectMetrics(b *testing.B) {
+	// 5000 processes with 100 distinct names
+	stats := map[string]map[int]Proc{}
+	for pid := 1; pid <= 5000; pid++ {
+		name := fmt.Sprintf("proc%d", pid%100)
+		if stats[name] == nil {
+			stats[name] = map[int]Proc{}
+		}
+		stats[name][pid] = makeMockProc(name, pid)
+	}
+	mc := &mcMock{}
+	mc.On("GetStats").Return(stats, nil)
+	procPlugin := New()
+	procPlugin.mc = mc
+
+	// up to 40 requested metrics, per process and aggregated ones
+	cfg := plugin.Config{"proc_path": "/proc"}
+	names := []string{"ps_vm", "ps_rss", "ps_rss_bytes", "ps_data", "ps_code", "ps_stacksize", "ps_threads",
+		"ps_cputime_user", "ps_cputime_system", "ps_pagefaults_min", "ps_pagefaults_maj", "ps_disk_ops_syscr",
+		"ps_disk_ops_syscw", "ps_disk_octets_rchar", "ps_disk_octets_wchar", "ps_fd_count", "ps_fd_utilization",
+		"ps_vm_peak", "ps_vm_swap", "ps_rss_anon"}
+	mts := []plugin.Metric{}
+	for _, name := range names {
+		for _, pid := range []string{"*", "all"} {
+			mts = append(mts, plugin.Metric{
+				Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", pid, name),
+				Config:    cfg,
+			})
+		}
+	}
+
+	// baseline is the former collection calculating metrics of each process for every requested metric
+	b.Run("per_metric_baseline/metrics=40", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			collectPerMetric(stats, mts)
+		}
+	})
+	for _, count := range []int{2, len(mts)} {
+		b.Run(fmt.Sprintf("snapshot/metrics=%d", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := procPlugin.CollectMetrics(mts[:count]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// collectPerMetric returns metrics of process instances the way they were collected before snapshots,
+// calling setProcMetrics for each instance once per requested metric
+func collectPerMetric(stats map[string]map[int]Proc, mts []plugin.Metric) []plugin.Metric {
+	metrics := []plugin.Metric{}
+	for _, mt := range mts {
+		ns := mt.Namespace
+		reqProcPID := ns[nsPid].Value
+		metricName := ns[nsPidMetric].Value
+		for processName, process := range stats {
+			aggregated := map[string]interface{}{}
+			for processPid, instance := range process {
+				if reqProcPID == "all" {
+					aggregateMetrics(aggregated, setProcMetrics(instance))
+					continue
+				}
+				nuns := append([]plugin.NamespaceElement{}, ns...)
+				nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
+				nuns[nsPid] = fillNsElement(&nuns[nsPid], strconv.Itoa(processPid))
+				metrics = append(metrics, prepareMetric(nuns, metricName, setProcMetrics(instance)[metricName]))
+			}
+			if reqProcPID == "all" {
+				nuns := append([]plugin.NamespaceElement{}, ns...)
+				nuns[nsProcName] = fillNsElement(&nuns[nsProcName], processName)
+				metrics = append(metrics, prepareMetric(nuns, metricName, aggregated[metricName]))
+			}
+		}
+	}
+	return metrics
+}
+
+func TestNewSnapshot(t *testing.T) {
+
+	stats := map[string]map[int]Proc{
+		mockProcName:  map[int]Proc{mockProcPid: mockProc},
+		mockProcName2: map[int]Proc{mockProcPid2: mockProc2, mockProcPid3: mockProc3},
+	}
+
+	Convey("when aggregates are requested", t, func() {
+		snap := New().newSnapshot(stats, nil, 1, true, nil, time.Now())
+
+		So(snap.metrics, ShouldHaveLength, 3)
+		So(snap.metrics[mockProcPid2]["ps_vm"], ShouldEqual, mockProc2.Stat.VSize)
+		So(snap.metrics[mockProcPid2]["ps_cputime_user_seconds"], ShouldNotBeNil)
+		So(snap.aggregated[mockProcName2]["ps_vm"], ShouldEqual, mockProc2.Stat.VSize+mockProc3.Stat.VSize)
+		So(snap.aggregated[mockProcName]["ps_vm"], ShouldEqual, mockProc.Stat.VSize)
+		// identifiers of parents are not aggregated
+		So(snap.metrics[mockProcPid2]["ps_ppid"], ShouldEqual, 1)
+		So(snap.aggregated[mockProcName2], ShouldNotContainKey, "ps_ppid")
+	})
+
+	Convey("when aggregates are not requested", t, func() {
+		snap := New().newSnapshot(stats, nil, 1, false, nil, time.Now())
+
+		So(snap.metrics, ShouldHaveLength, 3)
+		So(snap.aggregated[mockProcName2], ShouldBeEmpty)
+	})
+}
+
+func TestGetStatsOptions(t *testing.T) {
+
+	cfg := plugin.Config{"proc_path": "/proc", "exclude_users": "0", "exclude_kernel_threads": true}
+
+	Convey("when only process states are requested", t, func() {
+		opts, err := getStatsOptions([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "running"), Config: cfg},
+		})
+
+		// excluded processes would be counted in states anyway
+		So(err, ShouldBeNil)
+		So(opts.filter, ShouldBeNil)
+		So(opts.sources, ShouldBeEmpty)
+	})
+
+	Convey("when process metrics are requested", t, func() {
+		opts, err := getStatsOptions([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_vm"), Config: cfg},
+		})
+
+		So(err, ShouldBeNil)
+		So(opts.filter, ShouldNotBeNil)
+		So(opts.filter.excludeKernelThreads, ShouldBeTrue)
+		So(opts.filter.excludeUids, ShouldResemble, map[int]bool{0: true})
+		// owners of processes are read from status
+		So(opts.sources, ShouldResemble, map[string]bool{procCmd: true, procStatus: true})
+		So(opts.nameSource, ShouldEqual, nameArgv0)
+	})
+
+	Convey("when metrics aggregated by user are requested", t, func() {
+		opts, err := getStatsOptions([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "user", "*", "ps_vm"), Config: plugin.Config{"proc_path": "/proc"}},
+		})
+
+		// owners of processes are read from status, processes do not need to be named after command line
+		So(err, ShouldBeNil)
+		So(opts.filter, ShouldBeNil)
+		So(opts.sources, ShouldResemble, map[string]bool{procStatus: true})
+	})
+
+	Convey("when tags of process instances are configured", t, func() {
+		tagsCfg := plugin.Config{"proc_path": "/proc", "name_source": "comm", "tags": "pid,user,cgroup"}
+		opts, err := getStatsOptions([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_vm"), Config: tagsCfg},
+		})
+
+		// files needed by tags are read
+		So(err, ShouldBeNil)
+		So(opts.sources, ShouldResemble, map[string]bool{procStatus: true, procCgroup: true})
+
+		Convey("and only aggregated metrics are requested", func() {
+			opts, err := getStatsOptions([]plugin.Metric{
+				plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "all", "ps_vm"), Config: tagsCfg},
+			})
+
+			So(err, ShouldBeNil)
+			So(opts.sources, ShouldBeEmpty)
+		})
+	})
+
+	Convey("when metrics of grouping which is not enabled are requested", t, func() {
+		opts, err := getStatsOptions([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "unit", "*", "ps_vm"), Config: plugin.Config{"proc_path": "/proc"}},
+		})
+
+		// cgroups are not read as the metrics are not collected
+		So(err, ShouldBeNil)
+		So(opts.sources, ShouldBeEmpty)
+	})
+}
+
+func TestRequiredSources(t *testing.T) {
+
+	Convey("when only process states are requested", t, func() {
+		sources := requiredSources([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "state", "running")},
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "collector", "pids_scanned")},
+		}, procCmd)
+
+		// only stat is read
+		So(sources, ShouldBeEmpty)
+	})
+
+	Convey("when process metrics based on stat are requested", t, func() {
+		sources := requiredSources([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_vm")},
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "ps_count")},
+		}, procCmd)
+
+		// command line is needed to name processes
+		So(sources, ShouldResemble, map[string]bool{procCmd: true})
+
+		Convey("and processes are named after other source", func() {
+			mts := []plugin.Metric{
+				plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_vm")},
+			}
+
+			So(requiredSources(mts, nameSourceFiles[nameComm]), ShouldBeEmpty)
+			So(requiredSources(mts, nameSourceFiles[nameExe]), ShouldResemble, map[string]bool{procExe: true})
+			So(requiredSources(mts, nameSourceFiles[nameInterpreter]), ShouldResemble, map[string]bool{procCmd: true})
+		})
+	})
+
+	Convey("when process metrics based on other files are requested", t, func() {
+		sources := requiredSources([]plugin.Metric{
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "all", "ps_fd_utilization")},
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "ps_disk_octets_rchar")},
+			plugin.Metric{Namespace: plugin.NewNamespace("intel", "procfs", "processes", "process", "*", "*", "thread", "*", "thread_name")},
+		}, procCmd)
+
+		So(sources, ShouldResemble, map[string]bool{procCmd: true, procFd: true, procLimits: true, procIO: true, procTask: true})
+	})
+}
+
+func TestAggregate(t *testing.T) {
+
+	Convey("when values are summed by default", t, func() {
+		var acc interface{}
+		for _, val := range []uint64{3, 1, 2} {
+			acc = aggregate("", acc, val)
+		}
+		So(acc, ShouldEqual, 6)
+	})
+
+	Convey("when minimal value is aggregated", t, func() {
+		var acc interface{}
+		for _, val := range []uint64{3, 1, unlimited} {
+			acc = aggregate(aggrMin, acc, val)
+		}
+		So(acc, ShouldEqual, 1)
+	})
+
+	Convey("when maximal value is aggregated", t, func() {
+		var acc interface{}
+		for _, val := range []float64{0.5, 0.75, 0.25} {
+			acc = aggregate(aggrMax, acc, val)
+		}
+		So(acc, ShouldEqual, 0.75)
+	})
+
+	Convey("when value is not numeric", t, func() {
+		So(aggregate("", nil, "/usr/sbin/fake"), ShouldBeNil)
+	})
+}
+
 func (mp Proc) validateValue(param string, value uint64) bool {
 	ok := false
 
@@ -428,13 +1511,10 @@ func (mp Proc) validateValue(param string, value uint64) bool {
 	case "ps_data":
 		refValue = mp.VmData
 	case "ps_stacksize":
-		s1, _ := strconv.ParseUint(string(mp.Stat[27]), 10, 64)
-		s2, _ := strconv.ParseUint(string(mp.Stat[28]), 10, 64)
-
-		if s1 > s2 {
-			refValue = s1 - s2
+		if mp.Stat.StartStack > mp.Stat.KstkEsp {
+			refValue = mp.Stat.StartStack - mp.Stat.KstkEsp
 		} else {
-			refValue = s2 - s1
+			refValue = mp.Stat.KstkEsp - mp.Stat.StartStack
 		}
 
 	case "ps_disk_ops_syscr":
@@ -443,29 +1523,29 @@ func (mp Proc) validateValue(param string, value uint64) bool {
 	case "ps_disk_ops_syscw":
 		refValue = mp.Io["syscw"]
 	case "ps_rss":
-		rssSingle, _ := strconv.ParseUint(string(mp.Stat[23]), 10, 6)
-		refValue = rssSingle
+		refValue = mp.Stat.Rss
 	case "ps_code":
 		refValue = mp.VmCode
 	case "ps_cputime_system":
-		stime, _ := strconv.ParseUint(string(mp.Stat[14]), 10, 64)
-		refValue = stime * 10000
+		refValue = mp.Stat.Stime
 	case "ps_vm":
-		vm, _ := strconv.ParseUint(string(mp.Stat[22]), 10, 64)
-		refValue = vm
+		refValue = mp.Stat.VSize
 	case "ps_pagefaults_min":
-		minflt, _ := strconv.ParseUint(string(mp.Stat[9]), 10, 64)
-		refValue = minflt * 10000
+		refValue = mp.Stat.MinFlt
 	case "ps_pagefaults_maj":
-		majflt, _ := strconv.ParseUint(string(mp.Stat[11]), 10, 64)
-		refValue = majflt * 10000
+		refValue = mp.Stat.MajFlt
 	case "ps_disk_octets_rchar":
 		refValue = mp.Io["rchar"]
 	case "ps_disk_octets_wchar":
 		refValue = mp.Io["wchar"]
+	case "ps_disk_octets_read_bytes":
+		refValue = mp.Io["read_bytes"]
+	case "ps_disk_octets_write_bytes":
+		refValue = mp.Io["write_bytes"]
+	case "ps_disk_octets_cancelled_write_bytes":
+		refValue = mp.Io["cancelled_write_bytes"]
 	case "ps_cputime_user":
-		utime, _ := strconv.ParseUint(string(mp.Stat[13]), 10, 64)
-		refValue = utime * 10000
+		refValue = mp.Stat.Utime
 	default:
 		fmt.Println("invalid metric name", param)
 		return false
@@ -481,19 +1561,19 @@ func (mp Proc) validateValue(param string, value uint64) bool {
 }
 
 func makeMockProc(procName string, procPid int) Proc {
-	pidStr := strconv.Itoa(procPid)
+	stat, err := parseStat([]byte(fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 1077960960 3601 513 0 0 115 28 0 0 20 0 4 0 331 "+
+		"459870208 2145 18446744073709551615 140096990736384 140096992449927 140729036690976 140729036689856 "+
+		"140096924699517 0 0 4096 65536 0 0 0 17 7 0 0 3 0 0 140096994547816 140096994587072 140097024917504 "+
+		"140729036697458 140729036697495 140729036697495 140729036697567 0", procPid, procName, procPid, procPid)))
+	if err != nil {
+		panic(err)
+	}
+	fdCount := uint64(256)
 	res := Proc{
 		Pid:     procPid,
 		State:   "S",
 		CmdLine: "/usr/sbin/" + procName + " --no-daemon",
-
-		Stat: []string{
-			pidStr, "(" + procName + ")", "S", "1", pidStr, pidStr, "0", "-1", "1077960960", "3601", "513", "0", "0",
-			"115", "28", "0", "0", "20", "0", "4", "331", "459870208", "2145", "18446744073709551615", "140096990736384",
-			"140096992449927", "140729036690976", "140729036689856", "140096924699517", "0", "20483", "4096", "65536",
-			"18446744073709551615", "0", "0", "17", "7", "0", "0", "3", "0", "0", "140096994547816", "140096994587072",
-			"140097024917504", "140729036697458", "140729036697495", "140729036697495", "140729036697567", "0",
-		},
+		Stat:    stat,
 		Io: map[string]uint64{
 			"syscr":                 1100676,
 			"syscw":                 124253,
@@ -503,8 +1583,55 @@ func makeMockProc(procName string, procPid int) Proc {
 			"rchar":                 260972212,
 			"wchar":                 995958,
 		},
+		Status: map[string]uint64{
+			"VmPeak":   452000,
+			"VmHWM":    9000,
+			"VmSwap":   120,
+			"VmLck":    0,
+			"VmPin":    0,
+			"VmPTE":    96,
+			"RssAnon":  4480,
+			"RssFile":  4100,
+			"RssShmem": 0,
+			"VmData":   221884,
+			"VmExe":    1568,
+			"VmLib":    25004,
+
+			"voluntary_ctxt_switches":    7251,
+			"nonvoluntary_ctxt_switches": 38,
+		},
+		FdCount: &fdCount,
+		Limits: map[string]Limit{
+			"Max open files": Limit{Soft: 1024, Hard: 4096},
+			"Max processes":  Limit{Soft: 63432, Hard: 63432},
+			"Max stack size": Limit{Soft: 8388608, Hard: unlimited},
+		},
 		VmData: 227209216,
 		VmCode: 27209216,
 	}
 	return res
 }
+
+// createCgroupProcfs creates procfs tree of processes with given content of cgroup file in a temporary directory
+func createCgroupProcfs(cgroups map[int]string) (string, error) {
+	procPath, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		return "", err
+	}
+	for pid, cgroup := range cgroups {
+		dir := filepath.Join(procPath, strconv.Itoa(pid))
+		if err := os.Mkdir(dir, os.ModePerm); err != nil {
+			return "", err
+		}
+		files := map[string]string{
+			procStat:   fmt.Sprintf("%d (proc%d) %s\n", pid, pid, mockStatFields),
+			procCgroup: cgroup,
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm); err != nil {
+				return "", err
+			}
+		}
+	}
+	return procPath, nil
+}
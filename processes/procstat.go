@@ -20,11 +20,14 @@ limitations under the License.
 package processes
 
 import (
-	"fmt"
 	"io/ioutil"
+	"math"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/intelsdi-x/snap-plugin-utilities/str"
 	log "github.com/sirupsen/logrus"
@@ -35,6 +38,24 @@ const (
 	procStatus = "status"
 	procCmd    = "cmdline"
 	procIO     = "io"
+	procRollup = "smaps_rollup"
+	procSmaps  = "smaps"
+	procTask   = "task"
+	procFd     = "fd"
+	procLimits = "limits"
+	procExe    = "exe"
+	procCgroup = "cgroup"
+
+	// limitOpenFiles is name of the open file descriptors limit in /proc/<pid>/limits
+	limitOpenFiles = "Max open files"
+	// unlimited is value of resource limit which is not set
+	unlimited = math.MaxUint64
+
+	// unknownState counts processes with a state missing in States
+	unknownState = "unknown"
+
+	// maxWorkers is upper bound of number of goroutines reading PID dirs concurrently
+	maxWorkers = 1024
 )
 
 var (
@@ -50,6 +71,7 @@ var (
 		"K": "wakekill",
 		"W": "waking",
 		"P": "parked",
+		"I": "idle",
 	}
 )
 
@@ -58,14 +80,98 @@ type Proc struct {
 	Pid     int
 	State   string
 	CmdLine string
-	Stat    []string
+	Args    []string
+	Exe     string
+	Uids    []int
+	Cgroup  string
+	Stat    ProcStat
 	Io      map[string]uint64
+	Status  map[string]uint64
+	Smaps   map[string]uint64
+	Threads map[int]Thread
+	// FdCount is nil when proc/<pid>/fd was not read
+	FdCount *uint64
+	Limits  map[string]Limit
 	VmData  uint64
 	VmCode  uint64
+	// Unreadable holds errors of optional proc/<pid> files which could not be read,
+	// metrics based on them are not available
+	Unreadable map[string]error
+}
+
+// Limit holds soft and hard value of process resource limit
+type Limit struct {
+	Soft uint64
+	Hard uint64
+}
+
+// Thread holds statistics of a single thread (task) of the process
+type Thread struct {
+	Tid    int
+	Stat   ProcStat
+	Status map[string]uint64
+}
+
+// statsOptions controls data gathered by GetStats
+type statsOptions struct {
+	// sources are files of /proc/<pid> read in addition to stat, e.g. procIO;
+	// procSmaps enables reading memory usage from smaps_rollup or smaps
+	// and procTask enables reading statistics of each thread from task/<tid>
+	sources map[string]bool
+	// workers is number of goroutines reading PID dirs concurrently, at least one is used
+	workers int
+	// nameSource selects how processes are named, e.g. nameArgv0
+	nameSource string
+	// groupRules assign processes to named groups by their command line, they take precedence over nameSource
+	groupRules []groupRule
+	// filter excludes processes from process metrics, nil includes all processes
+	filter *procFilter
+}
+
+// scanStats holds summary of a single scan of procfs
+type scanStats struct {
+	// Scanned is number of PID directories found
+	Scanned uint64
+	// Vanished is number of PIDs skipped because the process exited during the scan
+	Vanished uint64
+	// Denied is number of PIDs skipped because their stat could not be read due to insufficient permissions
+	Denied uint64
+	// ParseErrors is number of PIDs skipped because their stat could not be parsed
+	ParseErrors uint64
+	// Excluded is number of PIDs excluded by filter
+	Excluded uint64
+	// ExcludedStates holds number of excluded processes by state code, e.g. "R"
+	ExcludedStates map[string]uint64
+	// PIDs holds PIDs of all directories found, including skipped ones
+	PIDs map[int]bool
 }
 
-// GetStats returns processes statistics
-func (psc *procStatsCollector) GetStats(procPath string) (map[string]map[int]Proc, error) {
+// skipReason tells why a PID was not reported
+type skipReason int
+
+const (
+	notSkipped skipReason = iota
+	skippedVanished
+	skippedDenied
+	skippedParseError
+	skippedExcluded
+)
+
+// pidDir is sub dir of procfs holding files of a process
+type pidDir struct {
+	pid  int
+	name string
+}
+
+// procResult is outcome of reading files of a single process
+type procResult struct {
+	name string
+	proc Proc
+	skip skipReason
+}
+
+// GetStats returns processes statistics and summary of the scan
+func (psc *procStatsCollector) GetStats(procPath string, opts statsOptions) (map[string]map[int]Proc, scanStats, error) {
 	// Procfs structure used in GetStats
 	// /proc
 	// |_ /[pid] (for example 922)
@@ -75,121 +181,277 @@ func (psc *procStatsCollector) GetStats(procPath string) (map[string]map[int]Pro
 	//    |_ status (Provides much of the information in /proc/[pid]/stat and
 	//               /proc/[pid]/statm in a format that's easier for humans to
 	//               parse)
+	//    |_ smaps_rollup (Memory consumption summed over all mappings, optional)
+	//    |_ smaps (Memory consumption for each mapping, optional, used when
+	//              smaps_rollup is not available)
+	//    |_ /fd (Open file descriptors of the process)
+	//    |_ limits (Resource limits of the process)
+	//    |_ /task (Threads of the process, optional)
+	//       |_ /[tid]
+	//          |_ stat (Status information about the thread)
+	//          |_ status (Status information about the thread in human readable format)
 	//
 	// For more details, check here:
 	// http://man7.org/linux/man-pages/man5/proc.5.html
 
+	ss := scanStats{ExcludedStates: map[string]uint64{}, PIDs: map[int]bool{}}
 	files, err := ioutil.ReadDir(procPath)
 	if err != nil {
-		return nil, err
+		return nil, ss, err
 	}
-	procs := map[string]map[int]Proc{}
+	// process only PID sub dirs
+	dirs := []pidDir{}
 	for _, file := range files {
-
-		// process only PID sub dirs
 		if pid, err := strconv.Atoi(file.Name()); err == nil {
-			// get proc/<pid>/stat data
-			fstat := filepath.Join(procPath, file.Name(), procStat)
-			procStat, err := ioutil.ReadFile(fstat)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"pid":   pid,
-					"file":  fstat,
-					"error": err,
-				}).Errorf("Cannot get status information about the process")
-				continue
-			}
-			// get proc/<pid>/cmdline data
-			fcmd := filepath.Join(procPath, file.Name(), procCmd)
-			procCmdLine, err := ioutil.ReadFile(fcmd)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"pid":   pid,
-					"file":  fcmd,
-					"error": err,
-				}).Errorf("Cannot get command line for the process")
-				continue
-			}
-			// get proc/<pid>/io data
-			fio := filepath.Join(procPath, file.Name(), procIO)
-			procIo, err := read2Map(fio)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"pid":   pid,
-					"file":  fio,
-					"error": err,
-				}).Errorf("Cannot get I/O statistics for the process")
-				continue
-			}
-			// get proc/<pid>/status data
-			var pStatus map[string]uint64
-			var vmData, vmCode uint64
-			// special case for zombie
-			state := strings.Fields(string(procStat))[2]
-			if state == "Z" {
-				vmData = 0
-				vmCode = 0
-			} else {
-				fstatus := filepath.Join(procPath, file.Name(), procStatus)
-				pStatus, err = read2Map(fstatus)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"pid":   pid,
-						"file":  fstatus,
-						"error": err,
-					}).Errorf("Cannot get status information for the process")
-					continue
-				}
-				vmData = pStatus["VmData"] * 1024
-				vmCode = (pStatus["VmExe"] + pStatus["VmLib"]) * 1024
-			}
+			dirs = append(dirs, pidDir{pid: pid, name: file.Name()})
+			ss.PIDs[pid] = true
+		}
+	}
 
-			procStatFields := strings.Fields(string(procStat))
-			if len(procStatFields) < 3 {
-				return nil, fmt.Errorf("Cannot retrieve process state")
-			}
-			procState := procStatFields[2]
-
-			// TODO: gather task status data /proc/<pid>/task
-			pc := Proc{
-				Pid:     pid,
-				State:   procState,
-				Stat:    procStatFields,
-				CmdLine: strings.Replace(string(procCmdLine), "\x00", " ", -1),
-				Io:      procIo,
-				VmData:  vmData,
-				VmCode:  vmCode,
-			}
-			// procName is process name extracted from command line path
-			procPath := strings.Split(pc.CmdLine, " ")[0]
-			procName := ""
-			if procPath != "" {
-				procName = filepath.Base(procPath)
-			} else {
-				// Kernel processes - no command line
-				procName = removeUnwantedChars(procStatFields[1])
-			}
-			if procName == "" {
-				return nil, fmt.Errorf("Cannot retrieve process name")
-			}
+	// results are merged in order of PID dirs, so they do not depend on number of workers
+	procs := map[string]map[int]Proc{}
+	for i, res := range readProcs(procPath, dirs, opts) {
+		ss.Scanned++
+		switch res.skip {
+		case skippedVanished:
+			ss.Vanished++
+			continue
+		case skippedDenied:
+			ss.Denied++
+			continue
+		case skippedParseError:
+			ss.ParseErrors++
+			continue
+		case skippedExcluded:
+			ss.Excluded++
+			ss.ExcludedStates[res.proc.State]++
+			continue
+		}
+
+		if procs[res.name] == nil {
+			procs[res.name] = map[int]Proc{}
+		}
+		procs[res.name][dirs[i].pid] = res.proc
+	}
+	return procs, ss, nil
+}
 
-			if procs[procName] == nil {
-				procs[procName] = map[int]Proc{}
+// readProcs reads statistics of processes in given PID dirs using opts.workers goroutines
+// and returns results in order of dirs
+func readProcs(procPath string, dirs []pidDir, opts statsOptions) []procResult {
+	results := make([]procResult, len(dirs))
+	workers := opts.workers
+	// workers above number of PID dirs would have nothing to read
+	if workers > len(dirs) {
+		workers = len(dirs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each result is written by a single worker to its own index
+			for i := range jobs {
+				results[i] = readProc(procPath, dirs[i].name, dirs[i].pid, opts)
 			}
-			procs[procName][pid] = pc
+		}()
+	}
+	for i := range dirs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// readProc reads statistics of a single process from procPath/dirName
+func readProc(procPath, dirName string, pid int, opts statsOptions) procResult {
+	// get proc/<pid>/stat data
+	fstat := filepath.Join(procPath, dirName, procStat)
+	procStatCont, err := ioutil.ReadFile(fstat)
+	if err != nil {
+		logReadError(pid, fstat, err, "Cannot get status information about the process")
+		// process which exited after listing procfs is expected
+		if os.IsPermission(err) {
+			return procResult{skip: skippedDenied}
+		}
+		return procResult{skip: skippedVanished}
+	}
+	pStat, err := parseStat(procStatCont)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"pid":   pid,
+			"file":  fstat,
+			"error": err,
+		}).Errorf("Cannot parse status information about the process")
+		return procResult{skip: skippedParseError}
+	}
+	// state of excluded process is still reported
+	excluded := procResult{skip: skippedExcluded, proc: Proc{Pid: pid, State: pStat.State, Stat: pStat}}
+	if opts.filter.excludesStat(pStat) {
+		return excluded
+	}
+	// files other than stat may be not accessible for processes of other users
+	// (e.g. with hidepid or Yama restrictions) so failure to read them is not fatal,
+	// the process is reported with data which was readable;
+	// only files needed by requested metrics are read
+	unreadable := map[string]error{}
+
+	// get proc/<pid>/cmdline data
+	var procCmdLine []byte
+	if opts.sources[procCmd] {
+		fcmd := filepath.Join(procPath, dirName, procCmd)
+		procCmdLine, err = ioutil.ReadFile(fcmd)
+		if err != nil {
+			unreadable[procCmd] = err
+			logReadError(pid, fcmd, err, "Cannot get command line for the process")
+		}
+	}
+	var args []string
+	if len(procCmdLine) > 0 {
+		args = strings.Split(strings.TrimSuffix(string(procCmdLine), "\x00"), "\x00")
+	}
+	// get proc/<pid>/exe target
+	var exe string
+	if opts.sources[procExe] {
+		fexe := filepath.Join(procPath, dirName, procExe)
+		exe, err = os.Readlink(fexe)
+		if err != nil {
+			unreadable[procExe] = err
+			logReadError(pid, fexe, err, "Cannot get executable of the process")
+		}
+	}
+	// get proc/<pid>/status data
+	var pStatus map[string]uint64
+	var uids []int
+	var vmData, vmCode uint64
+	if opts.sources[procStatus] {
+		fstatus := filepath.Join(procPath, dirName, procStatus)
+		pStatus, uids, err = readStatus(fstatus)
+		if err != nil {
+			unreadable[procStatus] = err
+			logReadError(pid, fstatus, err, "Cannot get status information for the process")
+		}
+		// special case for zombie, its owner is still known from status
+		if pStat.State != "Z" {
+			vmData = pStatus["VmData"] * 1024
+			vmCode = (pStatus["VmExe"] + pStatus["VmLib"]) * 1024
+		}
+	}
+
+	pc := Proc{
+		Pid:     pid,
+		State:   pStat.State,
+		Stat:    pStat,
+		CmdLine: strings.Replace(string(procCmdLine), "\x00", " ", -1),
+		Args:    args,
+		Exe:     exe,
+		Status:  pStatus,
+		Uids:    uids,
+		VmData:  vmData,
+		VmCode:  vmCode,
+
+		Unreadable: unreadable,
+	}
+	procName := groupName(opts.groupRules, pc.CmdLine)
+	if procName == "" {
+		procName = processName(pc, opts.nameSource)
+	}
+	// files not needed to name and filter processes are not read for excluded ones
+	if opts.filter.excludes(procName, pc) {
+		return excluded
+	}
+
+	// get proc/<pid>/io data
+	if opts.sources[procIO] {
+		fio := filepath.Join(procPath, dirName, procIO)
+		pc.Io, err = read2Map(fio)
+		if err != nil {
+			unreadable[procIO] = err
+			logReadError(pid, fio, err, "Cannot get I/O statistics for the process")
+		}
+	}
+
+	// get proc/<pid>/smaps_rollup data, it is not available for kernel threads and zombies
+	if opts.sources[procSmaps] && pStat.State != "Z" && pStat.Flags&pfKthread == 0 {
+		pc.Smaps, err = readSmaps(filepath.Join(procPath, dirName))
+		if err != nil {
+			unreadable[procSmaps] = err
+			logReadError(pid, procSmaps, err, "Cannot get memory mappings statistics for the process")
+		}
+	}
+
+	// get proc/<pid>/fd and proc/<pid>/limits data
+	if opts.sources[procFd] {
+		ffd := filepath.Join(procPath, dirName, procFd)
+		fdCount, err := countDir(ffd)
+		if err != nil {
+			unreadable[procFd] = err
+			logReadError(pid, ffd, err, "Cannot get file descriptors of the process")
+		} else {
+			pc.FdCount = &fdCount
+		}
+	}
+	if opts.sources[procLimits] {
+		flimits := filepath.Join(procPath, dirName, procLimits)
+		pc.Limits, err = readLimits(flimits)
+		if err != nil {
+			unreadable[procLimits] = err
+			logReadError(pid, flimits, err, "Cannot get resource limits of the process")
+		}
+	}
+
+	// get proc/<pid>/cgroup data
+	if opts.sources[procCgroup] {
+		fcgroup := filepath.Join(procPath, dirName, procCgroup)
+		pc.Cgroup, err = readCgroup(fcgroup)
+		if err != nil {
+			unreadable[procCgroup] = err
+			logReadError(pid, fcgroup, err, "Cannot get cgroup of the process")
 		}
 	}
-	return procs, nil
+
+	// get proc/<pid>/task data
+	if opts.sources[procTask] {
+		ftask := filepath.Join(procPath, dirName, procTask)
+		pc.Threads, err = readThreads(filepath.Join(procPath, dirName))
+		if err != nil {
+			unreadable[procTask] = err
+			logReadError(pid, ftask, err, "Cannot get threads statistics for the process")
+		}
+	}
+
+	return procResult{name: procName, proc: pc}
 }
 
 // readToMap retrieves statistics from file specified by filename and returns its (name, value) as a map
 func read2Map(fileName string) (map[string]uint64, error) {
-	stats := map[string]uint64{}
-	status, err := ioutil.ReadFile(fileName)
+	content, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return nil, err
 	}
-	for _, line := range strings.Split(string(status), "\n") {
+	return parse2Map(string(content)), nil
+}
+
+// readStatus retrieves statistics and UIDs of the process from status file specified by filename
+func readStatus(fileName string) (map[string]uint64, []int, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parse2Map(string(content)), parseUids(string(content)), nil
+}
+
+// parse2Map returns (name, value) statistics from content of a file as a map
+func parse2Map(content string) map[string]uint64 {
+	stats := map[string]uint64{}
+	for _, line := range strings.Split(content, "\n") {
 		if line == "" {
 			continue
 		}
@@ -213,6 +475,158 @@ func read2Map(fileName string) (map[string]uint64, error) {
 
 		stats[name] = value
 	}
+	return stats
+}
+
+// logReadError logs failure of reading file of the process, lack of permissions is expected
+// when running without privileges and files of processes which exited during the scan are gone,
+// so these are logged at debug level
+func logReadError(pid int, fileName string, err error, msg string) {
+	entry := log.WithFields(log.Fields{
+		"pid":   pid,
+		"file":  fileName,
+		"error": err,
+	})
+	// process may exit while its files are read (ESRCH)
+	if os.IsPermission(err) || os.IsNotExist(err) || isNoProcess(err) {
+		entry.Debug(msg)
+	} else {
+		entry.Error(msg)
+	}
+}
+
+// isNoProcess tells if err reports that the process does not exist anymore
+func isNoProcess(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.ESRCH
+}
+
+// countDir returns number of entries in directory specified by dirName
+func countDir(dirName string) (uint64, error) {
+	dir, err := os.Open(dirName)
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(names)), nil
+}
+
+// readLimits retrieves resource limits from file specified by filename and returns them as a map
+// keyed by limit name, "unlimited" values are reported as unlimited
+func readLimits(fileName string) (map[string]Limit, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	limits := map[string]Limit{}
+	for _, line := range strings.Split(string(content), "\n") {
+		// values may fill their columns leaving a single space between them,
+		// so fields are taken from the right: optional units, hard and soft limit,
+		// the rest is the limit name which contains single spaces
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !isLimitValue(fields[len(fields)-1]) {
+			fields = fields[:len(fields)-1]
+		}
+		if len(fields) < 3 || fields[0] == "Limit" {
+			continue
+		}
+		soft, err := parseLimit(fields[len(fields)-2])
+		if err != nil {
+			return nil, err
+		}
+		hard, err := parseLimit(fields[len(fields)-1])
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.Join(fields[:len(fields)-2], " ")] = Limit{Soft: soft, Hard: hard}
+	}
+	return limits, nil
+}
+
+// isLimitValue tells if field of /proc/<pid>/limits is a limit value, not units
+func isLimitValue(field string) bool {
+	_, err := parseLimit(field)
+	return err == nil
+}
+
+func parseLimit(value string) (uint64, error) {
+	if value == "unlimited" {
+		return unlimited, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readThreads retrieves statistics of all threads of the process,
+// threads which exit while being read are omitted
+func readThreads(pidPath string) (map[int]Thread, error) {
+	taskPath := filepath.Join(pidPath, procTask)
+	tasks, err := ioutil.ReadDir(taskPath)
+	if err != nil {
+		return nil, err
+	}
+	threads := map[int]Thread{}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		statCont, err := ioutil.ReadFile(filepath.Join(taskPath, task.Name(), procStat))
+		if err != nil {
+			continue
+		}
+		tStat, err := parseStat(statCont)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"tid":   tid,
+				"error": err,
+			}).Debug("Cannot parse status information about the thread")
+			continue
+		}
+		tStatus, err := read2Map(filepath.Join(taskPath, task.Name(), procStatus))
+		if err != nil {
+			continue
+		}
+		threads[tid] = Thread{Tid: tid, Stat: tStat, Status: tStatus}
+	}
+	return threads, nil
+}
+
+// readSmaps retrieves memory usage summed over all mappings of the process from smaps_rollup,
+// falls back to summing smaps on kernels which do not provide smaps_rollup (before 4.14)
+func readSmaps(pidPath string) (map[string]uint64, error) {
+	stats, err := sum2Map(filepath.Join(pidPath, procRollup))
+	if os.IsNotExist(err) {
+		return sum2Map(filepath.Join(pidPath, procSmaps))
+	}
+	return stats, err
+}
+
+// sum2Map retrieves statistics from file specified by filename and returns its (name, value) as a map
+// summing values which occur multiple times
+func sum2Map(fileName string) (map[string]uint64, error) {
+	stats := map[string]uint64{}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		data := strings.Fields(line)
+		// mapping header lines (address range, permissions...) are skipped as not ending with colon
+		if len(data) < 2 || !strings.HasSuffix(data[0], ":") {
+			continue
+		}
+		value, err := strconv.ParseUint(data[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[strings.TrimSuffix(data[0], ":")] += value
+	}
 	return stats, nil
 }
 
@@ -232,7 +646,7 @@ func removeUnwantedChars(str string) string {
 }
 
 type metricCollector interface {
-	GetStats(procPath string) (map[string]map[int]Proc, error)
+	GetStats(procPath string, opts statsOptions) (map[string]map[int]Proc, scanStats, error)
 }
 
 type unwanted struct {
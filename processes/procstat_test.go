@@ -22,7 +22,10 @@ limitations under the License.
 package processes
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -46,9 +49,13 @@ var (
 	mockFileStatusCont = []byte(`
 							Name:   mockProcName
 							State:  R (running)
+							VmPeak:	400
 							VmData: 100
 							VmExe:	100
 							VmLib:	100
+							VmHWM:	300
+							VmSwap:	10
+							RssAnon:	200
 							Tgid:   21926
 							Ngid:   0
 							Pid:    21926
@@ -78,6 +85,51 @@ var (
 									nonvoluntary_ctxt_switches:     0
 								`)
 
+	// mocked content of proc/<pid>/smaps_rollup
+	mockFileRollupCont = []byte(`00400000-7ffd6a5d6000 ---p 00000000 00:00 0                      [rollup]
+Rss:                7844 kB
+Pss:                1660 kB
+Shared_Clean:       6160 kB
+Shared_Dirty:          0 kB
+Private_Clean:       268 kB
+Private_Dirty:      1416 kB
+SwapPss:              12 kB
+`)
+
+	// mocked content of proc/<pid>/smaps
+	mockFileSmapsCont = []byte(`00400000-00452000 r-xp 00000000 08:02 173521      /usr/bin/dbus-daemon
+Rss:                7000 kB
+Pss:                1000 kB
+Private_Clean:       200 kB
+Private_Dirty:      1000 kB
+VmFlags: rd ex mr mw me dw
+7ffd6a5b5000-7ffd6a5d6000 rw-p 00000000 00:00 0                  [stack]
+Rss:                 844 kB
+Pss:                 660 kB
+Private_Clean:        68 kB
+Private_Dirty:       416 kB
+VmFlags: rd wr mr mw me gd ac
+`)
+
+	// mocked content of proc/<pid>/limits
+	mockFileLimitsCont = []byte(`Limit                     Soft Limit           Hard Limit           Units     
+Max cpu time              unlimited            unlimited            seconds   
+Max file size             unlimited            unlimited            bytes     
+Max stack size            8388608              unlimited            bytes     
+Max processes             63432                63432                processes 
+Max open files            1024                 4096                 files     
+Max nice priority         0                    0                    
+`)
+
+	// mocked content of proc/<pid>/cgroup on host with cgroup v1 and v2 hierarchies
+	mockFileCgroupCont = []byte(`12:memory:/system.slice/systemd-hostnamed.service
+1:name=systemd:/system.slice/systemd-hostnamed.service
+0::/system.slice/systemd-hostnamed.service
+`)
+
+	// number of mocked entries in proc/<pid>/fd
+	mockFdCount = 3
+
 	// mocked content of proc/<pid>/io
 	mockFileIoCont = []byte(`rchar: 10
 							wchar: 20
@@ -98,7 +150,7 @@ func TestGetStats(t *testing.T) {
 
 	Convey("when procfs directory does not exist", t, func() {
 		deleteMockFiles()
-		results, err := dut.GetStats(mockPath)
+		results, _, err := dut.GetStats(mockPath, mockOptions())
 
 		So(err, ShouldNotBeNil)
 		So(results, ShouldBeEmpty)
@@ -107,7 +159,7 @@ func TestGetStats(t *testing.T) {
 	Convey("when none process exist", t, func() {
 		deleteMockFiles()
 		os.Mkdir(mockPath, os.ModePerm)
-		results, err := dut.GetStats(mockPath)
+		results, _, err := dut.GetStats(mockPath, mockOptions())
 
 		So(results, ShouldBeEmpty)
 		So(err, ShouldBeNil)
@@ -120,18 +172,162 @@ func TestGetStats(t *testing.T) {
 			createMockFiles()
 			fileToRemove := mockPath + "/" + strconv.Itoa(mockPid[0]) + fileName
 			os.Remove(fileToRemove)
-			results, err := dut.GetStats(mockPath)
+			results, _, err := dut.GetStats(mockPath, mockOptions())
 
 			So(err, ShouldBeNil)
 			So(results, ShouldNotBeEmpty)
 		}
 	})
 
+	Convey("when optional files of process are not available", t, func() {
+		files := []string{"cmdline", "io", "status"}
+
+		for _, fileName := range files {
+			createMockFiles()
+			os.Remove(mockPath + "/" + strconv.Itoa(mockPid[0]) + "/" + fileName)
+			results, _, err := dut.GetStats(mockPath, mockOptions())
+
+			So(err, ShouldBeNil)
+			found := false
+			for _, process := range results {
+				if instance, ok := process[mockPid[0]]; ok {
+					found = true
+					So(instance.Unreadable, ShouldContainKey, fileName)
+				}
+			}
+			// process is reported with data which was readable
+			So(found, ShouldBeTrue)
+		}
+	})
+
+	Convey("when only stat of processes is required", t, func() {
+		createMockFiles()
+		results, _, err := dut.GetStats(mockPath, statsOptions{})
+
+		So(err, ShouldBeNil)
+		So(results, ShouldNotBeEmpty)
+		for name, process := range results {
+			// without command line processes are named after comm
+			So(name, ShouldEqual, "mockProcName")
+			for _, instance := range process {
+				So(instance.Stat.StartTime, ShouldEqual, 717086134)
+				So(instance.CmdLine, ShouldBeEmpty)
+				So(instance.Io, ShouldBeNil)
+				So(instance.Status, ShouldBeNil)
+				So(instance.Limits, ShouldBeNil)
+				So(instance.Unreadable, ShouldBeEmpty)
+			}
+		}
+	})
+
+	Convey("when processes are named after their executable", t, func() {
+		createMockFiles()
+		os.Symlink("/usr/sbin/mockexe"+deletedSuffix, mockPath+"/"+strconv.Itoa(mockPid[0])+"/exe")
+		opts := mockOptions(procExe)
+		opts.nameSource = nameExe
+		results, _, err := dut.GetStats(mockPath, opts)
+
+		So(err, ShouldBeNil)
+		So(results["mockexe"], ShouldContainKey, mockPid[0])
+		So(results["mockexe"][mockPid[0]].Exe, ShouldEqual, "/usr/sbin/mockexe"+deletedSuffix)
+		// comm is used for processes without readable executable
+		So(results["mockProcName"], ShouldHaveLength, len(mockPid)-1)
+		So(results["mockProcName"][mockPid[1]].Unreadable, ShouldContainKey, procExe)
+	})
+
+	Convey("when processes are assigned to groups", t, func() {
+		createMockFiles()
+		f, _ := os.Create(mockPath + "/" + strconv.Itoa(mockPid[0]) + "/cmdline")
+		f.WriteString("/usr/bin/python3\x00/opt/etl_worker.py\x00--queue=orders\x00")
+		f.Close()
+		opts := mockOptions()
+		opts.groupRules, _ = parseGroupRules(`etl-${1}=etl_worker\.py --queue=(\w+)`)
+		results, _, err := dut.GetStats(mockPath, opts)
+
+		So(err, ShouldBeNil)
+		So(results["etl-orders"], ShouldHaveLength, 1)
+		So(results["etl-orders"], ShouldContainKey, mockPid[0])
+		// processes not matching any group are named as usual
+		So(results["systemd-hostnamed^@"], ShouldHaveLength, len(mockPid)-1)
+	})
+
+	Convey("when processes are excluded by filter", t, func() {
+		createMockFiles()
+		f, _ := os.Create(mockPath + "/" + strconv.Itoa(mockPid[0]) + "/cmdline")
+		f.WriteString("/usr/sbin/nginx\x00-g\x00daemon off;\x00")
+		f.Close()
+		// files of excluded processes other than needed by filter are not read
+		os.Remove(mockPath + "/" + strconv.Itoa(mockPid[1]) + "/io")
+		opts := mockOptions()
+		opts.filter, _ = newProcFilter("nginx", "", "", nil, false)
+		results, scan, err := dut.GetStats(mockPath, opts)
+
+		So(err, ShouldBeNil)
+		So(results, ShouldHaveLength, 1)
+		So(results["nginx"], ShouldContainKey, mockPid[0])
+		So(results["nginx"][mockPid[0]].Io, ShouldNotBeEmpty)
+		So(scan.Scanned, ShouldEqual, len(mockPid))
+		So(scan.Excluded, ShouldEqual, len(mockPid)-1)
+		// states of excluded processes are reported
+		So(scan.ExcludedStates, ShouldResemble, map[string]uint64{"R": uint64(len(mockPid) - 1)})
+
+		Convey("and by owner", func() {
+			opts.filter, _ = newProcFilter("", "", "", map[int]bool{0: true}, false)
+			results, scan, err := dut.GetStats(mockPath, opts)
+
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+			So(scan.Excluded, ShouldEqual, len(mockPid))
+		})
+	})
+
+	Convey("when procfs is scanned by multiple workers", t, func() {
+		createMockFiles()
+		expected, expectedScan, err := dut.GetStats(mockPath, mockOptions(procSmaps, procTask))
+		So(err, ShouldBeNil)
+
+		for _, workers := range []int{2, 3, len(mockPid) + 1} {
+			opts := mockOptions(procSmaps, procTask)
+			opts.workers = workers
+			results, scan, err := dut.GetStats(mockPath, opts)
+
+			// results are the same as of sequential scan
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, expected)
+			So(scan, ShouldResemble, expectedScan)
+		}
+	})
+
+	Convey("when there are more workers than PID dirs", t, func() {
+		So(readProcs(mockPath, nil, statsOptions{workers: maxWorkers}), ShouldBeEmpty)
+	})
+
+	Convey("when stat of processes cannot be read or parsed", t, func() {
+		createMockFiles()
+		os.Remove(mockPath + "/" + strconv.Itoa(mockPid[0]) + "/stat")
+		f, _ := os.Create(mockPath + "/" + strconv.Itoa(mockPid[1]) + "/stat")
+		f.WriteString("broken")
+		f.Close()
+		results, scan, err := dut.GetStats(mockPath, mockOptions())
+
+		So(err, ShouldBeNil)
+		instances := 0
+		for _, process := range results {
+			instances += len(process)
+		}
+		So(instances, ShouldEqual, len(mockPid)-2)
+		// skipped processes are counted by reason
+		So(scan.Scanned, ShouldEqual, len(mockPid))
+		So(scan.Vanished, ShouldEqual, 1)
+		So(scan.ParseErrors, ShouldEqual, 1)
+		So(scan.Denied, ShouldEqual, 0)
+	})
+
 	Convey("when proc files are available", t, func() {
 
 		Convey("when proccess is not in a zombie state", func() {
 			createMockFiles()
-			results, err := dut.GetStats(mockPath)
+			results, _, err := dut.GetStats(mockPath, mockOptions())
 
 			So(err, ShouldBeNil)
 			So(results, ShouldNotBeEmpty)
@@ -149,6 +345,10 @@ func TestGetStats(t *testing.T) {
 					So(instance.State, ShouldEqual, "R")
 					So(instance.VmData, ShouldEqual, 100*1024)
 					So(instance.VmCode, ShouldEqual, (100+100)*1024) // equal to (VmExe+VMLib)*1024
+					So(instance.Status["VmPeak"], ShouldEqual, 400)
+					So(instance.Status["VmHWM"], ShouldEqual, 300)
+					So(instance.Status["VmSwap"], ShouldEqual, 10)
+					So(instance.Status["RssAnon"], ShouldEqual, 200)
 
 					So(instance.CmdLine, ShouldResemble, string(mockFileCmdlineCont))
 
@@ -163,12 +363,181 @@ func TestGetStats(t *testing.T) {
 			}
 		})
 
+		Convey("when file descriptors and limits are available", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions())
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(instance.Unreadable, ShouldBeEmpty)
+					So(instance.FdCount, ShouldNotBeNil)
+					So(*instance.FdCount, ShouldEqual, mockFdCount)
+					So(instance.Limits[limitOpenFiles], ShouldResemble, Limit{Soft: 1024, Hard: 4096})
+					So(instance.Limits["Max stack size"], ShouldResemble, Limit{Soft: 8388608, Hard: unlimited})
+					So(instance.Limits["Max cpu time"], ShouldResemble, Limit{Soft: unlimited, Hard: unlimited})
+					So(instance.Limits["Max nice priority"], ShouldResemble, Limit{Soft: 0, Hard: 0})
+				}
+			}
+		})
+
+		Convey("when file descriptors and limits are not available", func() {
+			createMockFiles()
+			dir := mockPath + "/" + strconv.Itoa(mockPid[0])
+			os.RemoveAll(dir + "/fd")
+			os.Remove(dir + "/limits")
+			results, _, err := dut.GetStats(mockPath, mockOptions())
+
+			So(err, ShouldBeNil)
+			instances := 0
+			for _, process := range results {
+				for pid, instance := range process {
+					instances++
+					if pid != mockPid[0] {
+						continue
+					}
+					So(instance.Unreadable, ShouldContainKey, "fd")
+					So(instance.Unreadable, ShouldContainKey, "limits")
+					So(instance.Limits, ShouldBeNil)
+				}
+			}
+			// process is not skipped
+			So(instances, ShouldEqual, len(mockPid))
+		})
+
+		Convey("when cgroups of processes are requested", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions(procCgroup))
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(instance.Cgroup, ShouldEqual, "/system.slice/systemd-hostnamed.service")
+				}
+			}
+		})
+
+		Convey("when threads statistics are not requested", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions())
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(instance.Threads, ShouldBeNil)
+				}
+			}
+		})
+
+		Convey("when threads statistics are requested", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions(procTask))
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(len(instance.Threads), ShouldEqual, 2)
+					So(instance.Threads, ShouldContainKey, instance.Pid)
+					So(instance.Threads, ShouldContainKey, instance.Pid+1)
+					for tid, thread := range instance.Threads {
+						So(thread.Tid, ShouldEqual, tid)
+						So(thread.Stat.Comm, ShouldEqual, "mockProcName")
+						So(thread.Status["voluntary_ctxt_switches"], ShouldEqual, 1)
+					}
+				}
+			}
+		})
+
+		Convey("when memory mappings statistics are not requested", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions())
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(instance.Smaps, ShouldBeNil)
+				}
+			}
+		})
+
+		Convey("when memory mappings statistics are requested", func() {
+			createMockFiles()
+			results, _, err := dut.GetStats(mockPath, mockOptions(procSmaps))
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					So(instance.Smaps["Pss"], ShouldEqual, 1660)
+					So(instance.Smaps["Private_Dirty"], ShouldEqual, 1416)
+					So(instance.Smaps["SwapPss"], ShouldEqual, 12)
+				}
+			}
+		})
+
+		Convey("when memory mappings statistics are requested for kernel threads", func() {
+			procPath, err := ioutil.TempDir("", "procfs")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(procPath)
+			// kthreadd has PF_KTHREAD flag, no command line and no memory mappings
+			dir := filepath.Join(procPath, "2")
+			So(os.Mkdir(dir, os.ModePerm), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, procStat), []byte("2 (kthreadd) S 0 0 0 0 -1 2129984 0 0 0 0 0 11 0 0 20 0 1 0 2 "+
+				"0 0 18446744073709551615 0 0 0 0 0 0 0 2147483647 0 0 0 0 0 1 0 0 0 0 0 0 0 0 0 0 0 0 0\n"), os.ModePerm), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, procCmd), nil, os.ModePerm), ShouldBeNil)
+
+			results, _, err := dut.GetStats(procPath, statsOptions{sources: map[string]bool{procCmd: true, procSmaps: true}})
+
+			// smaps_rollup of kernel threads is not read
+			So(err, ShouldBeNil)
+			So(results["kthreadd"], ShouldContainKey, 2)
+			So(results["kthreadd"][2].Smaps, ShouldBeNil)
+			So(results["kthreadd"][2].Unreadable, ShouldBeEmpty)
+		})
+
+		Convey("when process name consists of unwanted characters only", func() {
+			procPath, err := ioutil.TempDir("", "procfs")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(procPath)
+			// any user may run a binary named [], its command line is not read when only stat is needed
+			dir := filepath.Join(procPath, "4321")
+			So(os.Mkdir(dir, os.ModePerm), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, procStat), []byte("4321 ([]) S 1 4321 4321 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 200 "+
+				"0 0 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n"), os.ModePerm), ShouldBeNil)
+
+			results, scan, err := dut.GetStats(procPath, statsOptions{sources: map[string]bool{}})
+
+			// the process is named after its PID and the scan is not aborted
+			So(err, ShouldBeNil)
+			So(scan.Scanned, ShouldEqual, 1)
+			So(results["4321"], ShouldContainKey, 4321)
+			So(results["4321"][4321].State, ShouldEqual, "S")
+		})
+
+		Convey("when memory mappings statistics are requested and smaps_rollup is not available", func() {
+			createMockFiles()
+			for _, pid := range mockPid {
+				os.Remove(mockPath + "/" + strconv.Itoa(pid) + "/smaps_rollup")
+			}
+			results, _, err := dut.GetStats(mockPath, mockOptions(procSmaps))
+
+			So(err, ShouldBeNil)
+			for _, instances := range results {
+				for _, instance := range instances {
+					// values summed over all mappings
+					So(instance.Smaps["Rss"], ShouldEqual, 7844)
+					So(instance.Smaps["Pss"], ShouldEqual, 1660)
+					So(instance.Smaps["Private_Clean"], ShouldEqual, 268)
+					So(instance.Smaps["Private_Dirty"], ShouldEqual, 1416)
+				}
+			}
+		})
+
 		Convey("when process is a zombie", func() {
 			// change status in mockFileStatCont to zombie (Z)
 			mockFileStatCont = []byte(strings.Replace(string(mockFileStatCont), " R ", " Z ", 1))
 
 			createMockFiles()
-			results, err := dut.GetStats(mockPath)
+			results, _, err := dut.GetStats(mockPath, mockOptions())
 
 			So(err, ShouldBeNil)
 			So(results, ShouldNotBeEmpty)
@@ -191,6 +560,101 @@ func TestGetStats(t *testing.T) {
 	deleteMockFiles()
 }
 
+func TestReadLimits(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("when limit value fills its column", t, func() {
+		fileName := filepath.Join(dir, "limits")
+		ioutil.WriteFile(fileName, []byte(`Limit                     Soft Limit           Hard Limit           Units     
+Max file locks            18446744073709551614 unlimited            locks     
+Max locked memory         65536                18446744073709551614 bytes     
+Max realtime priority     0                    0                    
+Max realtime timeout      unlimited            unlimited            us        
+`), os.ModePerm)
+		limits, err := readLimits(fileName)
+
+		So(err, ShouldBeNil)
+		So(limits, ShouldHaveLength, 4)
+		So(limits["Max file locks"], ShouldResemble, Limit{Soft: 18446744073709551614, Hard: unlimited})
+		So(limits["Max locked memory"], ShouldResemble, Limit{Soft: 65536, Hard: 18446744073709551614})
+		So(limits["Max realtime priority"], ShouldResemble, Limit{Soft: 0, Hard: 0})
+		So(limits["Max realtime timeout"], ShouldResemble, Limit{Soft: unlimited, Hard: unlimited})
+	})
+}
+
+func BenchmarkGetStats(b *testing.B) {
+	// synthetic procfs with number of processes of a large host
+	procPath, err := createBenchProcfs(5000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(procPath)
+	dut := &procStatsCollector{}
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			opts := mockOptions()
+			opts.workers = workers
+			for i := 0; i < b.N; i++ {
+				if _, _, err := dut.GetStats(procPath, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// createBenchProcfs creates procfs tree with given number of processes in a temporary directory
+func createBenchProcfs(count int) (string, error) {
+	procPath, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		return "", err
+	}
+	for pid := 1; pid <= count; pid++ {
+		dir := filepath.Join(procPath, strconv.Itoa(pid))
+		files := map[string][]byte{
+			"stat":    []byte(fmt.Sprintf("%d (bench%d) %s\n", pid, pid%50, mockStatFields)),
+			"cmdline": []byte(fmt.Sprintf("/usr/bin/bench%d\x00--id=%d\x00", pid%50, pid)),
+			"status":  mockFileStatusCont,
+			"io":      mockFileIoCont,
+			"limits":  mockFileLimitsCont,
+		}
+		if err := os.MkdirAll(filepath.Join(dir, procFd), os.ModePerm); err != nil {
+			return "", err
+		}
+		for fd := 0; fd < mockFdCount; fd++ {
+			files[filepath.Join(procFd, strconv.Itoa(fd))] = nil
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), content, os.ModePerm); err != nil {
+				return "", err
+			}
+		}
+	}
+	return procPath, nil
+}
+
+// mockOptions returns options reading files of processes which are read by default
+// together with given optional files
+func mockOptions(optional ...string) statsOptions {
+	opts := statsOptions{sources: map[string]bool{
+		procCmd:    true,
+		procIO:     true,
+		procStatus: true,
+		procFd:     true,
+		procLimits: true,
+	}}
+	for _, source := range optional {
+		opts.sources[source] = true
+	}
+	return opts
+}
+
 func createMockFiles() {
 	deleteMockFiles()
 	os.Mkdir(mockPath, os.ModePerm)
@@ -211,6 +675,34 @@ func createMockFiles() {
 
 		f, _ = os.Create(dir + "/io")
 		f.Write(mockFileIoCont)
+
+		f, _ = os.Create(dir + "/limits")
+		f.Write(mockFileLimitsCont)
+
+		f, _ = os.Create(dir + "/cgroup")
+		f.Write(mockFileCgroupCont)
+
+		os.Mkdir(dir+"/fd", os.ModePerm)
+		for fd := 0; fd < mockFdCount; fd++ {
+			os.Create(dir + "/fd/" + strconv.Itoa(fd))
+		}
+
+		f, _ = os.Create(dir + "/smaps_rollup")
+		f.Write(mockFileRollupCont)
+
+		f, _ = os.Create(dir + "/smaps")
+		f.Write(mockFileSmapsCont)
+
+		for _, tid := range []int{pid, pid + 1} {
+			taskDir := dir + "/task/" + strconv.Itoa(tid)
+			os.MkdirAll(taskDir, os.ModePerm)
+
+			f, _ = os.Create(taskDir + "/stat")
+			f.Write(mockFileStatCont)
+
+			f, _ = os.Create(taskDir + "/status")
+			f.Write(mockFileStatusCont)
+		}
 	}
 }
 
@@ -0,0 +1,136 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"sync"
+	"time"
+)
+
+// rateCounters are cumulative per-process metrics whose change between collections is tracked
+var rateCounters = []string{
+	"ps_cputime_user",
+	"ps_cputime_system",
+	"ps_ctxt_switches_voluntary",
+	"ps_ctxt_switches_nonvoluntary",
+	"ps_disk_octets_read_bytes",
+	"ps_disk_octets_write_bytes",
+	"ps_disk_octets_cancelled_write_bytes",
+}
+
+// rateMetrics maps rateCounters to metrics reporting their per-second rates
+var rateMetrics = map[string]string{
+	"ps_ctxt_switches_voluntary":    "ps_ctxt_switches_voluntary_rate",
+	"ps_ctxt_switches_nonvoluntary": "ps_ctxt_switches_nonvoluntary_rate",
+
+	"ps_disk_octets_read_bytes":            "ps_disk_octets_read_bytes_rate",
+	"ps_disk_octets_write_bytes":           "ps_disk_octets_write_bytes_rate",
+	"ps_disk_octets_cancelled_write_bytes": "ps_disk_octets_cancelled_write_bytes_rate",
+}
+
+// procKey identifies process instance across collections, start time distinguishes reused PIDs
+type procKey struct {
+	pid       int
+	startTime uint64
+}
+
+// counterSample holds value of a counter and time it was read at
+type counterSample struct {
+	value     uint64
+	timestamp time.Time
+}
+
+// sample holds last seen values of rateCounters of a process instance
+type sample struct {
+	counters map[string]counterSample
+}
+
+// rateTracker calculates per-second rates of rateCounters from consecutive collections;
+// the plugin instance is shared by tasks requesting different metrics (so reading different files)
+// and filtering different processes, so counters are tracked separately and kept until the process is gone
+type rateTracker struct {
+	mutex   sync.Mutex
+	samples map[procKey]sample
+}
+
+func newRateTracker() *rateTracker {
+	return &rateTracker{samples: map[procKey]sample{}}
+}
+
+// update stores current values of rateCounters taken from metrics of process instances by PID
+// and returns their per-second rates per PID since the counters were seen last time;
+// counters seen for the first time have no rates, samples of processes whose PID is not in live PIDs
+// or is reused by another process are dropped
+func (rt *rateTracker) update(stats map[string]map[int]Proc, live map[int]bool, procMetrics map[int]map[string]interface{}, now time.Time) map[int]map[string]float64 {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	rates := map[int]map[string]float64{}
+	startTimes := map[int]uint64{}
+	for _, process := range stats {
+		for pid, instance := range process {
+			startTimes[pid] = instance.Stat.StartTime
+			key := procKey{pid: pid, startTime: instance.Stat.StartTime}
+
+			prev, ok := rt.samples[key]
+			if !ok {
+				prev = sample{counters: map[string]counterSample{}}
+				rt.samples[key] = prev
+			}
+			// counters based on files which were not read are missing in metrics, their previous samples are kept
+			for _, name := range rateCounters {
+				val, ok := procMetrics[pid][name].(uint64)
+				if !ok {
+					continue
+				}
+				last, seen := prev.counters[name]
+				prev.counters[name] = counterSample{value: val, timestamp: now}
+				elapsed := now.Sub(last.timestamp).Seconds()
+				// counters are not expected to decrease for the same process instance
+				if !seen || elapsed <= 0 || val < last.value {
+					continue
+				}
+				if rates[pid] == nil {
+					rates[pid] = map[string]float64{}
+				}
+				rates[pid][name] = float64(val-last.value) / elapsed
+			}
+		}
+	}
+
+	// processes not reported in this collection (e.g. excluded by filter) may still be running
+	for key := range rt.samples {
+		startTime, reported := startTimes[key.pid]
+		if (reported && startTime != key.startTime) || (!reported && !live[key.pid]) {
+			delete(rt.samples, key)
+		}
+	}
+
+	return rates
+}
+
+// setRates sets metrics reporting per-second rates of rateCounters
+func setRates(procMetrics map[string]interface{}, rates map[string]float64) {
+	for counter, metricName := range rateMetrics {
+		if rate, ok := rates[counter]; ok {
+			procMetrics[metricName] = rate
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateTracker(t *testing.T) {
+
+	Convey("rate tracker", t, func() {
+		rt := newRateTracker()
+		now := time.Now()
+		proc := makeMockProc("fake", 315)
+		stats := map[string]map[int]Proc{"fake": map[int]Proc{315: proc}}
+
+		Convey("when process is seen for the first time", func() {
+			rates := rt.update(stats, nil, baseMetrics(stats), now)
+
+			So(rates, ShouldBeEmpty)
+		})
+
+		Convey("when process is seen in consecutive collections", func() {
+			rt.update(stats, nil, baseMetrics(stats), now)
+
+			proc.Stat.Utime += 200
+			proc.Stat.Stime += 50
+			proc.Status = map[string]uint64{
+				"voluntary_ctxt_switches":    proc.Status["voluntary_ctxt_switches"] + 1000,
+				"nonvoluntary_ctxt_switches": proc.Status["nonvoluntary_ctxt_switches"] + 10,
+			}
+			proc.Io = map[string]uint64{
+				"read_bytes":            proc.Io["read_bytes"] + 4096,
+				"write_bytes":           proc.Io["write_bytes"] + 8192,
+				"cancelled_write_bytes": proc.Io["cancelled_write_bytes"],
+			}
+			stats["fake"][315] = proc
+			rates := rt.update(stats, nil, baseMetrics(stats), now.Add(2*time.Second))
+
+			So(rates[315]["ps_cputime_user"], ShouldEqual, 100)
+			So(rates[315]["ps_cputime_system"], ShouldEqual, 25)
+			So(rates[315]["ps_ctxt_switches_voluntary"], ShouldEqual, 500)
+			So(rates[315]["ps_ctxt_switches_nonvoluntary"], ShouldEqual, 5)
+			So(rates[315]["ps_disk_octets_read_bytes"], ShouldEqual, 2048)
+			So(rates[315]["ps_disk_octets_write_bytes"], ShouldEqual, 4096)
+			So(rates[315]["ps_disk_octets_cancelled_write_bytes"], ShouldEqual, 0)
+		})
+
+		Convey("when I/O statistics are not read in previous collection", func() {
+			withoutIO := proc
+			withoutIO.Io = nil
+			stats["fake"][315] = withoutIO
+			rt.update(stats, nil, baseMetrics(stats), now)
+
+			proc.Io = map[string]uint64{
+				"read_bytes":            proc.Io["read_bytes"] + 4096,
+				"write_bytes":           proc.Io["write_bytes"] + 8192,
+				"cancelled_write_bytes": proc.Io["cancelled_write_bytes"],
+			}
+			stats["fake"][315] = proc
+			rates := rt.update(stats, nil, baseMetrics(stats), now.Add(2*time.Second))
+
+			// I/O counters are not reset to zero, so there is no rate until next collection with I/O statistics
+			So(rates[315], ShouldContainKey, "ps_cputime_user")
+			So(rates[315], ShouldNotContainKey, "ps_disk_octets_read_bytes")
+			So(rates[315], ShouldNotContainKey, "ps_disk_octets_write_bytes")
+
+			proc.Io["read_bytes"] += 2048
+			stats["fake"][315] = proc
+			rates = rt.update(stats, nil, baseMetrics(stats), now.Add(4*time.Second))
+
+			So(rates[315]["ps_disk_octets_read_bytes"], ShouldEqual, 1024)
+			So(rates[315]["ps_disk_octets_write_bytes"], ShouldEqual, 0)
+		})
+
+		Convey("when PID is reused by another process", func() {
+			rt.update(stats, nil, baseMetrics(stats), now)
+
+			proc.Stat.StartTime++
+			stats["fake"][315] = proc
+			rates := rt.update(stats, nil, baseMetrics(stats), now.Add(time.Second))
+
+			So(rates, ShouldBeEmpty)
+		})
+
+		Convey("when process is gone", func() {
+			rt.update(stats, nil, baseMetrics(stats), now)
+			rt.update(map[string]map[int]Proc{}, nil, nil, now.Add(time.Second))
+
+			So(rt.samples, ShouldBeEmpty)
+		})
+
+		Convey("when process is not reported but still running", func() {
+			rt.update(stats, nil, baseMetrics(stats), now)
+			// e.g. excluded by filter of another task
+			rt.update(map[string]map[int]Proc{}, map[int]bool{315: true}, nil, now.Add(time.Second))
+
+			So(rt.samples, ShouldHaveLength, 1)
+
+			proc.Stat.Utime += 200
+			stats["fake"][315] = proc
+			rates := rt.update(stats, nil, baseMetrics(stats), now.Add(2*time.Second))
+
+			So(rates[315]["ps_cputime_user"], ShouldEqual, 100)
+		})
+
+		Convey("when PID of process which is not reported is reused", func() {
+			rt.update(stats, nil, baseMetrics(stats), now)
+			proc.Stat.StartTime++
+			stats["fake"][315] = proc
+			rt.update(stats, map[int]bool{315: true}, baseMetrics(stats), now.Add(time.Second))
+
+			So(rt.samples, ShouldHaveLength, 1)
+			So(rt.samples, ShouldContainKey, procKey{pid: 315, startTime: proc.Stat.StartTime})
+		})
+	})
+}
+
+// baseMetrics returns metrics of process instances by PID
+func baseMetrics(stats map[string]map[int]Proc) map[int]map[string]interface{} {
+	procMetrics := map[int]map[string]interface{}{}
+	for _, process := range stats {
+		for pid, instance := range process {
+			procMetrics[pid] = setProcMetrics(instance)
+		}
+	}
+	return procMetrics
+}
+
+func TestSetRates(t *testing.T) {
+
+	Convey("when rates of counters are available", t, func() {
+		procMetrics := map[string]interface{}{}
+		setRates(procMetrics, map[string]float64{"ps_ctxt_switches_voluntary": 500, "ps_ctxt_switches_nonvoluntary": 5})
+
+		So(procMetrics["ps_ctxt_switches_voluntary_rate"], ShouldEqual, 500)
+		So(procMetrics["ps_ctxt_switches_nonvoluntary_rate"], ShouldEqual, 5)
+	})
+
+	Convey("when rates of counters are not available", t, func() {
+		procMetrics := map[string]interface{}{}
+		setRates(procMetrics, nil)
+
+		So(procMetrics, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,151 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minStatFields is number of fields following comm in /proc/<pid>/stat
+// which are required to fill ProcStat (up to and including kstkesp)
+const minStatFields = 27
+
+// ProcStat holds fields of /proc/<pid>/stat used by the plugin,
+// see proc(5) for their meaning
+type ProcStat struct {
+	Pid        int
+	Comm       string
+	State      string
+	Ppid       int
+	Pgrp       int
+	Session    int
+	TtyNr      int
+	Tpgid      int
+	Flags      uint64
+	MinFlt     uint64
+	CMinFlt    uint64
+	MajFlt     uint64
+	CMajFlt    uint64
+	Utime      uint64
+	Stime      uint64
+	CUtime     int64
+	CStime     int64
+	Priority   int64
+	Nice       int64
+	NumThreads int64
+	StartTime  uint64
+	VSize      uint64
+	Rss        uint64
+	RssLim     uint64
+	StartCode  uint64
+	EndCode    uint64
+	StartStack uint64
+	KstkEsp    uint64
+}
+
+// parseStat parses content of /proc/<pid>/stat.
+// Comm is enclosed in parentheses and may contain spaces and parentheses itself,
+// so it spans from the first '(' to the last ')' and remaining fields are indexed from there.
+func parseStat(data []byte) (ProcStat, error) {
+	var ps ProcStat
+
+	content := string(data)
+	open := strings.IndexByte(content, '(')
+	end := strings.LastIndexByte(content, ')')
+	if open < 0 || end < open {
+		return ps, fmt.Errorf("Cannot find process name in stat data")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(content[:open]))
+	if err != nil {
+		return ps, fmt.Errorf("Cannot parse pid from stat data: %v", err)
+	}
+	ps.Pid = pid
+	ps.Comm = content[open+1 : end]
+
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < minStatFields {
+		return ps, fmt.Errorf("Process stat data broken, expected at least %d fields after comm, got %d", minStatFields, len(fields))
+	}
+	ps.State = fields[0]
+
+	p := statParser{fields: fields}
+	ps.Ppid = p.int(1)
+	ps.Pgrp = p.int(2)
+	ps.Session = p.int(3)
+	ps.TtyNr = p.int(4)
+	ps.Tpgid = p.int(5)
+	ps.Flags = p.uint(6)
+	ps.MinFlt = p.uint(7)
+	ps.CMinFlt = p.uint(8)
+	ps.MajFlt = p.uint(9)
+	ps.CMajFlt = p.uint(10)
+	ps.Utime = p.uint(11)
+	ps.Stime = p.uint(12)
+	ps.CUtime = p.int64(13)
+	ps.CStime = p.int64(14)
+	ps.Priority = p.int64(15)
+	ps.Nice = p.int64(16)
+	ps.NumThreads = p.int64(17)
+	ps.StartTime = p.uint(19)
+	ps.VSize = p.uint(20)
+	ps.Rss = p.uint(21)
+	ps.RssLim = p.uint(22)
+	ps.StartCode = p.uint(23)
+	ps.EndCode = p.uint(24)
+	ps.StartStack = p.uint(25)
+	ps.KstkEsp = p.uint(26)
+
+	if p.err != nil {
+		return ps, p.err
+	}
+	return ps, nil
+}
+
+// statParser converts fields following comm, keeping the first error encountered
+type statParser struct {
+	fields []string
+	err    error
+}
+
+func (p *statParser) int64(i int) int64 {
+	v, err := strconv.ParseInt(p.fields[i], 10, 64)
+	p.setErr(i, err)
+	return v
+}
+
+func (p *statParser) int(i int) int {
+	return int(p.int64(i))
+}
+
+func (p *statParser) uint(i int) uint64 {
+	v, err := strconv.ParseUint(p.fields[i], 10, 64)
+	p.setErr(i, err)
+	return v
+}
+
+func (p *statParser) setErr(i int, err error) {
+	if err != nil && p.err == nil {
+		// field numbers in proc(5) start from 1 and comm is the 2nd one
+		p.err = fmt.Errorf("Cannot parse stat field %d: %v", i+3, err)
+	}
+}
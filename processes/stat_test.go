@@ -0,0 +1,90 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockStatFields is content of proc/<pid>/stat following comm
+const mockStatFields = "S 9018 9018 3635 34817 9018 4243788 121 0 7 0 1500 300 0 0 20 0 3 0 717086134 " +
+	"459870208 2145 18446744073709551615 4194304 4238788 140729036690976 140729036689856 0 0 0 0 0 0 0 0 0 17 5 0 0 0 0 0"
+
+func TestParseStat(t *testing.T) {
+
+	Convey("when comm is hostile", t, func() {
+		comms := []string{
+			"bash",
+			"Web Content",
+			"tmux: server",
+			"a) R 1 2 (b",
+			") (",
+			"((nested))",
+			"",
+			"new\nline",
+			"0 1 2 3 4 5",
+		}
+
+		for _, comm := range comms {
+			ps, err := parseStat([]byte(fmt.Sprintf("21926 (%s) %s\n", comm, mockStatFields)))
+
+			So(err, ShouldBeNil)
+			So(ps.Pid, ShouldEqual, 21926)
+			So(ps.Comm, ShouldEqual, comm)
+			So(ps.State, ShouldEqual, "S")
+			So(ps.Ppid, ShouldEqual, 9018)
+			So(ps.Tpgid, ShouldEqual, 9018)
+			So(ps.MinFlt, ShouldEqual, 121)
+			So(ps.MajFlt, ShouldEqual, 7)
+			So(ps.Utime, ShouldEqual, 1500)
+			So(ps.Stime, ShouldEqual, 300)
+			So(ps.NumThreads, ShouldEqual, 3)
+			So(ps.StartTime, ShouldEqual, 717086134)
+			So(ps.VSize, ShouldEqual, 459870208)
+			So(ps.Rss, ShouldEqual, 2145)
+			So(ps.StartStack, ShouldEqual, uint64(140729036690976))
+			So(ps.KstkEsp, ShouldEqual, uint64(140729036689856))
+		}
+	})
+
+	Convey("when stat data is broken", t, func() {
+		broken := []string{
+			"",
+			"21926 bash " + mockStatFields,
+			"21926 (bash " + mockStatFields,
+			"21926 bash) " + mockStatFields,
+			"abc (bash) " + mockStatFields,
+			"21926 (bash) S 9018 9018",
+			"21926 (bash) S x 9018 3635 34817 9018 4243788 121 0 7 0 1500 300 0 0 20 0 3 0 717086134 " +
+				"459870208 2145 18446744073709551615 4194304 4238788 140729036690976 140729036689856",
+		}
+
+		for _, content := range broken {
+			_, err := parseStat([]byte(content))
+
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Tags of metrics of process instances selected by tags config
+	tagHostname  = "hostname"
+	tagPid       = "pid"
+	tagPpid      = "ppid"
+	tagUser      = "user"
+	tagCmdline   = "cmdline"
+	tagCgroup    = "cgroup"
+	tagContainer = "container_id"
+	tagPod       = "pod_uid"
+	tagState     = "state"
+	tagStartTime = "start_time"
+
+	// bootTimeField is field of /proc/stat holding boot time in seconds since the epoch
+	bootTimeField = "btime"
+	// hostStat is file of procfs holding boot time
+	hostStat = "stat"
+)
+
+// tagFiles maps tags to files of /proc/<pid> their values are read from, other tags are based on stat
+var tagFiles = map[string]string{
+	tagHostname:  "",
+	tagPid:       "",
+	tagPpid:      "",
+	tagUser:      procStatus,
+	tagCmdline:   procCmd,
+	tagCgroup:    procCgroup,
+	tagContainer: procCgroup,
+	tagPod:       procCgroup,
+	tagState:     "",
+	tagStartTime: "",
+}
+
+// procTagger attaches tags to metrics of process instances
+type procTagger struct {
+	names []string
+	host  string
+	// cmdlineMaxLength is maximal length of cmdline tag, it is not truncated when 0
+	cmdlineMaxLength int
+	// user is used to name owner of the process
+	user groupKey
+	// bootTime and clockTicks are used to calculate start time of the process
+	bootTime   time.Time
+	clockTicks uint64
+}
+
+// parseTags returns names of tags from comma separated list
+func parseTags(tags string) ([]string, error) {
+	names := splitList(tags)
+	for _, name := range names {
+		if _, ok := tagFiles[name]; !ok {
+			return nil, fmt.Errorf("unknown tag %q", name)
+		}
+	}
+	return names, nil
+}
+
+// tags returns tags of the process instance, tags which cannot be determined are not set
+func (t *procTagger) tags(instance Proc) map[string]string {
+	if t == nil {
+		return nil
+	}
+	tags := map[string]string{}
+	for _, name := range t.names {
+		switch name {
+		case tagHostname:
+			tags[name] = t.host
+		case tagPid:
+			tags[name] = strconv.Itoa(instance.Pid)
+		case tagPpid:
+			tags[name] = strconv.Itoa(instance.Stat.Ppid)
+		case tagUser:
+			if user, ok := t.user(instance); ok {
+				tags[name] = user
+			}
+		case tagCmdline:
+			// separator of the last argument is trimmed, kernel threads have empty command line
+			cmdLine := strings.TrimRight(instance.CmdLine, " ")
+			if _, unreadable := instance.Unreadable[procCmd]; !unreadable && cmdLine != "" {
+				tags[name] = truncate(cmdLine, t.cmdlineMaxLength)
+			}
+		case tagCgroup:
+			if instance.Cgroup != "" {
+				tags[name] = instance.Cgroup
+			}
+		case tagContainer:
+			if container := cgroupContainer(instance.Cgroup); container != "" {
+				tags[name] = container
+			}
+		case tagPod:
+			if pod := cgroupPod(instance.Cgroup); pod != "" {
+				tags[name] = pod
+			}
+		case tagState:
+			if state, ok := States[instance.State]; ok {
+				tags[name] = state
+			} else {
+				tags[name] = unknownState
+			}
+		case tagStartTime:
+			if !t.bootTime.IsZero() && t.clockTicks > 0 {
+				// start time is in clock ticks since boot
+				seconds, ticks := instance.Stat.StartTime/t.clockTicks, instance.Stat.StartTime%t.clockTicks
+				start := t.bootTime.Add(time.Duration(seconds)*time.Second + time.Duration(ticks)*time.Second/time.Duration(t.clockTicks))
+				tags[name] = start.UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	return tags
+}
+
+// truncate returns value shortened to at most maxLength bytes without splitting multi-byte characters,
+// value is not truncated when maxLength is 0
+func truncate(value string, maxLength int) string {
+	if maxLength <= 0 || len(value) <= maxLength {
+		return value
+	}
+	for maxLength > 0 && !utf8.RuneStart(value[maxLength]) {
+		maxLength--
+	}
+	return value[:maxLength]
+}
+
+// readBootTime returns boot time of the host from stat file of procfs specified by fileName
+func readBootTime(fileName string) (time.Time, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != bootTimeField {
+			continue
+		}
+		btime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(btime, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%s not found in %s", bootTimeField, fileName)
+}
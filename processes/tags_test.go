@@ -0,0 +1,174 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTags(t *testing.T) {
+
+	Convey("when known tags are given", t, func() {
+		names, err := parseTags("hostname, pid,cmdline")
+
+		So(err, ShouldBeNil)
+		So(names, ShouldResemble, []string{tagHostname, tagPid, tagCmdline})
+	})
+
+	Convey("when no tags are given", t, func() {
+		names, err := parseTags("")
+
+		So(err, ShouldBeNil)
+		So(names, ShouldBeEmpty)
+	})
+
+	Convey("when unknown tag is given", t, func() {
+		_, err := parseTags("pid,uid")
+
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestProcTagger(t *testing.T) {
+
+	instance := makeMockProc("nginx", 1234)
+	instance.Uids = []int{0, 33, 33, 33}
+	instance.Cgroup = "/system.slice/nginx.service"
+	instance.CmdLine = "nginx: worker process "
+
+	Convey("when all tags are attached", t, func() {
+		tagger := &procTagger{
+			names:      []string{tagHostname, tagPid, tagPpid, tagUser, tagCmdline, tagCgroup, tagState, tagStartTime},
+			host:       "node1",
+			user:       userKey(map[int]string{33: "www-data"}, uidEffective),
+			bootTime:   time.Unix(1500000000, 0),
+			clockTicks: 100,
+		}
+
+		So(tagger.tags(instance), ShouldResemble, map[string]string{
+			tagHostname:  "node1",
+			tagPid:       "1234",
+			tagPpid:      "1",
+			tagUser:      "www-data",
+			tagCmdline:   "nginx: worker process",
+			tagCgroup:    "/system.slice/nginx.service",
+			tagState:     "sleeping",
+			tagStartTime: "2017-07-14T02:40:03Z",
+		})
+	})
+
+	Convey("when process runs in a container of Kubernetes pod", t, func() {
+		tagger := &procTagger{names: []string{tagCgroup, tagContainer, tagPod}}
+		pod := instance
+		pod.Cgroup = "/kubepods/besteffort/pod0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0/" +
+			"3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+		So(tagger.tags(pod), ShouldResemble, map[string]string{
+			tagCgroup:    pod.Cgroup,
+			tagContainer: "3f4e5c0b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f",
+			tagPod:       "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0",
+		})
+	})
+
+	Convey("when process runs outside of containers", t, func() {
+		tagger := &procTagger{names: []string{tagContainer, tagPod}}
+
+		So(tagger.tags(instance), ShouldBeEmpty)
+	})
+
+	Convey("when process is a kernel thread", t, func() {
+		tagger := &procTagger{names: []string{tagCmdline}}
+		kthread := makeMockProc("kthreadd", 2)
+		kthread.CmdLine = ""
+
+		So(tagger.tags(kthread), ShouldBeEmpty)
+	})
+
+	Convey("when command line is truncated", t, func() {
+		tagger := &procTagger{names: []string{tagCmdline}, cmdlineMaxLength: 5}
+
+		So(tagger.tags(instance), ShouldResemble, map[string]string{tagCmdline: "nginx"})
+	})
+
+	Convey("when attributes of the process are not available", t, func() {
+		tagger := &procTagger{
+			names: []string{tagUser, tagCmdline, tagCgroup, tagContainer, tagPod, tagStartTime},
+			user:  userKey(map[int]string{}, uidEffective),
+		}
+		unknown := makeMockProc("nginx", 1234)
+		unknown.Unreadable = map[string]error{procCmd: os.ErrPermission}
+
+		So(tagger.tags(unknown), ShouldBeEmpty)
+	})
+
+	Convey("when no tags are configured", t, func() {
+		var tagger *procTagger
+
+		So(tagger.tags(instance), ShouldBeNil)
+	})
+}
+
+func TestTruncate(t *testing.T) {
+
+	Convey("when value is longer than maximal length", t, func() {
+		So(truncate("/usr/bin/python3", 8), ShouldEqual, "/usr/bin")
+		// multi-byte characters are not split
+		So(truncate("zażółć", 3), ShouldEqual, "za")
+	})
+
+	Convey("when value is not longer than maximal length", t, func() {
+		So(truncate("bash", 4), ShouldEqual, "bash")
+		So(truncate("bash", 0), ShouldEqual, "bash")
+	})
+}
+
+func TestReadBootTime(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "procfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("when boot time is available", t, func() {
+		fileName := filepath.Join(dir, "stat")
+		ioutil.WriteFile(fileName, []byte("cpu  10 0 20 300 0 0 0 0 0 0\nintr 100 0 1\nctxt 200\nbtime 1500000000\nprocesses 300\n"), os.ModePerm)
+		bootTime, err := readBootTime(fileName)
+
+		So(err, ShouldBeNil)
+		So(bootTime.Unix(), ShouldEqual, 1500000000)
+	})
+
+	Convey("when boot time is not available", t, func() {
+		fileName := filepath.Join(dir, "stat_without_btime")
+		ioutil.WriteFile(fileName, []byte("cpu  10 0 20 300 0 0 0 0 0 0\n"), os.ModePerm)
+		_, err := readBootTime(fileName)
+
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,93 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"strconv"
+)
+
+const (
+	// initPid is PID of init, its children are top-level processes of process subtrees
+	initPid = 1
+)
+
+// topLevelAncestors returns top-level ancestor of each process given by PIDs of parents of processes;
+// top-level processes are children of init, processes without parent (e.g. init and kthreadd)
+// and processes whose parent was not found (e.g. exited or excluded by filters);
+// orphans are reparented by the kernel to init or the closest subreaper, so they are assigned
+// to subtree of the subreaper or become top-level themselves;
+// cycles of parents, which may be read when PIDs are reused during the scan, are broken at the lowest PID
+func topLevelAncestors(parents map[int]int) map[int]int {
+	top := map[int]int{}
+	for pid := range parents {
+		path := []int{}
+		onPath := map[int]int{}
+		root := pid
+		for current := pid; ; {
+			if ancestor, ok := top[current]; ok {
+				root = ancestor
+				break
+			}
+			if i, ok := onPath[current]; ok {
+				// cycle consists of processes on path from the first visit of the current one
+				root = current
+				for _, p := range path[i:] {
+					if p < root {
+						root = p
+					}
+				}
+				break
+			}
+			onPath[current] = len(path)
+			path = append(path, current)
+			parent := parents[current]
+			if _, ok := parents[parent]; !ok || parent == initPid {
+				root = current
+				break
+			}
+			current = parent
+		}
+		for _, p := range path {
+			top[p] = root
+		}
+	}
+	return top
+}
+
+// ancestorKey groups processes by subtree of the top-level process they belong to, group is named
+// after name and PID of the top-level process, e.g. supervisord:812; the tree is built once from stats
+func ancestorKey(stats map[string]map[int]Proc) groupKey {
+	parents := map[int]int{}
+	names := map[int]string{}
+	for processName, process := range stats {
+		for pid, instance := range process {
+			parents[pid] = instance.Stat.Ppid
+			names[pid] = processName
+		}
+	}
+	top := topLevelAncestors(parents)
+	return func(instance Proc) (string, bool) {
+		ancestor, ok := top[instance.Pid]
+		if !ok {
+			return "", false
+		}
+		return names[ancestor] + ":" + strconv.Itoa(ancestor), true
+	}
+}
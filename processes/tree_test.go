@@ -0,0 +1,114 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTopLevelAncestors(t *testing.T) {
+
+	Convey("when processes form a tree", t, func() {
+		// init (1) and kthreadd (2) have no parent
+		parents := map[int]int{
+			1: 0, 2: 0, 3: 2, 4: 2,
+			// supervisord with workers and their children
+			100: 1, 101: 100, 102: 100, 103: 102,
+			// make -j build
+			200: 1, 201: 200, 202: 201, 203: 201,
+		}
+		top := topLevelAncestors(parents)
+
+		So(top, ShouldResemble, map[int]int{
+			1: 1, 2: 2, 3: 2, 4: 2,
+			100: 100, 101: 100, 102: 100, 103: 100,
+			200: 200, 201: 200, 202: 200, 203: 200,
+		})
+	})
+
+	Convey("when orphans are reparented", t, func() {
+		parents := map[int]int{
+			1: 0,
+			// daemonized process reparented to init is top-level itself
+			300: 1,
+			// processes reparented to subreaper (e.g. systemd --user or containerd-shim) stay in its subtree
+			400: 1, 401: 400, 402: 400, 403: 402,
+		}
+		top := topLevelAncestors(parents)
+
+		So(top[300], ShouldEqual, 300)
+		So(top[401], ShouldEqual, 400)
+		So(top[402], ShouldEqual, 400)
+		So(top[403], ShouldEqual, 400)
+	})
+
+	Convey("when parent of the process was not found", t, func() {
+		// parent 500 exited or was excluded during the scan
+		top := topLevelAncestors(map[int]int{1: 0, 501: 500, 502: 501})
+
+		So(top[501], ShouldEqual, 501)
+		So(top[502], ShouldEqual, 501)
+	})
+
+	Convey("when parents form a cycle", t, func() {
+		// PIDs reused during the scan may form a cycle, process 600 leads to the cycle
+		parents := map[int]int{1: 0, 600: 603, 601: 602, 602: 603, 603: 601}
+
+		for i := 0; i < 10; i++ {
+			top := topLevelAncestors(parents)
+
+			// cycle is broken at the lowest PID regardless of order of walking the tree
+			So(top, ShouldResemble, map[int]int{1: 1, 600: 601, 601: 601, 602: 601, 603: 601})
+		}
+
+		Convey("and process is its own parent", func() {
+			So(topLevelAncestors(map[int]int{700: 700}), ShouldResemble, map[int]int{700: 700})
+		})
+	})
+}
+
+func TestAncestorKey(t *testing.T) {
+
+	Convey("when processes are grouped by top-level ancestor", t, func() {
+		initProc, supervisord, worker := makeMockProc("systemd", 1), makeMockProc("supervisord", 100), makeMockProc("python", 101)
+		initProc.Stat.Ppid, supervisord.Stat.Ppid, worker.Stat.Ppid = 0, 1, 100
+		key := ancestorKey(map[string]map[int]Proc{
+			"systemd":     map[int]Proc{1: initProc},
+			"supervisord": map[int]Proc{100: supervisord},
+			"python":      map[int]Proc{101: worker},
+		})
+
+		group, ok := key(worker)
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "supervisord:100")
+
+		group, ok = key(initProc)
+		So(ok, ShouldBeTrue)
+		So(group, ShouldEqual, "systemd:1")
+
+		// process missing in the scan has no group
+		_, ok = key(makeMockProc("bash", 200))
+		So(ok, ShouldBeFalse)
+	})
+}
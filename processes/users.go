@@ -0,0 +1,102 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// passwdPath is path of user accounts database
+	passwdPath = "/etc/passwd"
+
+	// Indexes of UIDs in Uid line of /proc/<pid>/status
+	uidReal      = 0
+	uidEffective = 1
+)
+
+// readPasswd returns user names by UID from passwd file specified by fileName
+func readPasswd(fileName string) (map[int]string, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	users := map[int]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		// the first entry is used for UIDs shared by multiple names
+		if _, ok := users[uid]; !ok {
+			users[uid] = fields[0]
+		}
+	}
+	return users, nil
+}
+
+// resolveUids returns UIDs of users given by names or numeric UIDs,
+// names missing in passwd are skipped
+func resolveUids(users []string, passwd map[int]string) map[int]bool {
+	uidsByName := map[string]int{}
+	for uid, name := range passwd {
+		uidsByName[name] = uid
+	}
+	uids := map[int]bool{}
+	for _, user := range users {
+		if uid, ok := uidsByName[user]; ok {
+			uids[uid] = true
+		} else if uid, err := strconv.Atoi(user); err == nil {
+			uids[uid] = true
+		} else {
+			log.WithField("user", user).Warn("Unknown user")
+		}
+	}
+	return uids
+}
+
+// parseUids returns real, effective, saved set and filesystem UIDs from content of /proc/<pid>/status
+func parseUids(status string) []int {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uids := []int{}
+		for _, field := range fields[1:] {
+			uid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil
+			}
+			uids = append(uids, uid)
+		}
+		return uids
+	}
+	return nil
+}
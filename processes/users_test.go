@@ -0,0 +1,83 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015-2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package processes
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const mockPasswd = `root:x:0:0:root:/root:/bin/bash
+# comment:x:1:1::/:/bin/false
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+toor:x:0:0:root:/root:/bin/bash
+broken:x:uid:1::/:/bin/false
+builder:x:1001:1001:CI builder,,,:/home/builder:/bin/bash
+`
+
+func TestReadPasswd(t *testing.T) {
+
+	Convey("when passwd is available", t, func() {
+		f, err := ioutil.TempFile("", "passwd")
+		So(err, ShouldBeNil)
+		defer os.Remove(f.Name())
+		f.WriteString(mockPasswd)
+		f.Close()
+
+		users, err := readPasswd(f.Name())
+
+		So(err, ShouldBeNil)
+		// the first name of shared UID is used
+		So(users, ShouldResemble, map[int]string{0: "root", 1: "daemon", 1001: "builder"})
+	})
+
+	Convey("when passwd is not available", t, func() {
+		_, err := readPasswd("./mocktest/passwd")
+
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestResolveUids(t *testing.T) {
+
+	Convey("when users are given by names and UIDs", t, func() {
+		uids := resolveUids([]string{"builder", "0", "65534", "nobody"}, map[int]string{0: "root", 1001: "builder"})
+
+		// unknown names are skipped
+		So(uids, ShouldResemble, map[int]bool{1001: true, 0: true, 65534: true})
+	})
+}
+
+func TestParseUids(t *testing.T) {
+
+	Convey("when status contains UIDs", t, func() {
+		So(parseUids("Name:\tsudo\nUid:\t1000\t0\t0\t0\nGid:\t1000\t1000\t1000\t1000\n"), ShouldResemble, []int{1000, 0, 0, 0})
+	})
+
+	Convey("when status does not contain valid UIDs", t, func() {
+		So(parseUids("Name:\tsudo\n"), ShouldBeNil)
+		So(parseUids("Uid:\tx\t0\t0\t0\n"), ShouldBeNil)
+	})
+}